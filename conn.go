@@ -0,0 +1,154 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// Addr is the net.Addr of a serial port, it holds the name of the port.
+type Addr struct {
+	Name string
+}
+
+// Network returns the name of the network ("serial")
+func (a *Addr) Network() string {
+	return "serial"
+}
+
+// String returns the name of the serial port
+func (a *Addr) String() string {
+	return a.Name
+}
+
+// NewConn returns a net.Conn that reads from and writes to the given port.
+// Both the local and the remote address of the connection are an Addr
+// holding the name of the port, if the port implements PortNamer.
+//
+// Errors are reported as *net.OpError, like the connections of the net
+// package: an expired deadline wraps os.ErrDeadlineExceeded and the operations
+// on a closed port wrap net.ErrClosed. Deadlines are supported only if the
+// port implements DeadlineSetter, otherwise the SetDeadline methods fail with
+// a FunctionNotImplemented error.
+// The read timeout of the port (see SetReadTimeout) is not reported to the
+// caller of Read: the read is retried until some data is received or an error
+// occurs, use SetReadDeadline instead.
+func NewConn(port Port) net.Conn {
+	addr := &Addr{}
+	if named, ok := port.(PortNamer); ok {
+		addr.Name = named.PortName()
+	}
+	return &conn{port: port, addr: addr}
+}
+
+type conn struct {
+	port Port
+	addr *Addr
+}
+
+// connReadRetryDelay is the minimum interval between two reads of conn.Read
+// while no data is received.
+var connReadRetryDelay = 10 * time.Millisecond
+
+func (c *conn) Read(b []byte) (int, error) {
+	for {
+		start := time.Now()
+		n, err := c.port.Read(b)
+		if err != nil {
+			return n, c.opError("read", err)
+		}
+		if n > 0 || len(b) == 0 {
+			return n, nil
+		}
+		// The read timeout of the port expired, try again. Wait a bit
+		// if the read returned immediately (for example with a zero
+		// read timeout), so the retries don't spin.
+		if time.Since(start) < connReadRetryDelay {
+			time.Sleep(connReadRetryDelay)
+		}
+	}
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.port.Write(b)
+	if err != nil {
+		return n, c.opError("write", err)
+	}
+	return n, nil
+}
+
+func (c *conn) Close() error {
+	if err := c.port.Close(); err != nil {
+		return c.opError("close", err)
+	}
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	port, err := c.deadlineSetter()
+	if err == nil {
+		err = port.SetDeadline(t)
+	}
+	if err != nil {
+		return c.opError("set", err)
+	}
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	port, err := c.deadlineSetter()
+	if err == nil {
+		err = port.SetReadDeadline(t)
+	}
+	if err != nil {
+		return c.opError("set", err)
+	}
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	port, err := c.deadlineSetter()
+	if err == nil {
+		err = port.SetWriteDeadline(t)
+	}
+	if err != nil {
+		return c.opError("set", err)
+	}
+	return nil
+}
+
+func (c *conn) deadlineSetter() (DeadlineSetter, error) {
+	port, ok := c.port.(DeadlineSetter)
+	if !ok {
+		return nil, &PortError{code: FunctionNotImplemented}
+	}
+	return port, nil
+}
+
+// opError wraps err in a net.OpError, translating the errors that have
+// a well-known counterpart in the net package.
+func (c *conn) opError(op string, err error) error {
+	if portErr, ok := err.(*PortError); ok {
+		switch portErr.Code() {
+		case DeadlineExceeded:
+			err = os.ErrDeadlineExceeded
+		case PortClosed:
+			err = net.ErrClosed
+		}
+	}
+	return &net.OpError{Op: op, Net: c.addr.Network(), Source: c.addr, Addr: c.addr, Err: err}
+}
@@ -0,0 +1,66 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+// idlePort is a fake Port with a zero read timeout and without deadlines: Read
+// returns immediately, and receives some data only after a given time.
+type idlePort struct {
+	Port
+	name  string
+	after time.Time
+	reads int
+}
+
+func (p *idlePort) Read(b []byte) (int, error) {
+	p.reads++
+	if time.Now().Before(p.after) {
+		return 0, nil
+	}
+	return copy(b, "data"), nil
+}
+
+func (p *idlePort) SetRTS(rts bool) error {
+	return nil
+}
+
+func (p *idlePort) PortName() string {
+	return p.name
+}
+
+func TestConnReadRetry(t *testing.T) {
+	port := &idlePort{after: time.Now().Add(100 * time.Millisecond)}
+	buf := make([]byte, 10)
+	n, err := NewConn(port).Read(buf)
+	if err != nil || string(buf[:n]) != "data" {
+		t.Fatalf("unexpected read %q %v", buf[:n], err)
+	}
+	// The retries don't spin
+	if port.reads > 20 {
+		t.Fatalf("%d reads in 100ms", port.reads)
+	}
+}
+
+func TestConnAddr(t *testing.T) {
+	port := &idlePort{name: "/dev/ttyUSB0"}
+	if addr := NewConn(port).LocalAddr(); addr.String() != "/dev/ttyUSB0" || addr.Network() != "serial" {
+		t.Fatalf("unexpected address %v", addr)
+	}
+
+	// The wrappers report the name of the underlying port
+	rs485, err := NewSoftRS485Port(port, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := NewConn(rs485).RemoteAddr(); addr.String() != "/dev/ttyUSB0" {
+		t.Fatalf("unexpected address %v", addr)
+	}
+}
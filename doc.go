@@ -48,6 +48,21 @@ The following snippets shows how to declare a configuration for 57600_E71:
 		StopBits: serial.OneStopBit,
 	}
 
+Additional settings can be given by opening the port with the OpenWithOptions
+function, for example to wait for I/O through the Go runtime poller:
+
+	port, err := serial.OpenWithOptions("/dev/ttyUSB0",
+		serial.WithMode(mode),
+		serial.WithRuntimePoller())
+
+Since the device names may change across reboots, a port can also be selected
+by the properties of its USB device with the OpenMatching function and the
+PortFilter of the enumerator package (OpenMatchingContext also waits until the
+device is connected):
+
+	filter := &enumerator.PortFilter{VID: "0403", PID: "6001", SerialNumber: "A50285BI"}
+	port, err := serial.OpenMatching(filter, mode)
+
 The configuration can be changed at any time with the SetMode function:
 
 	err := port.SetMode(mode)
@@ -79,6 +94,25 @@ serial port:
 		fmt.Printf("%v", string(buff[:n]))
 	}
 
+The port can also be wrapped in a net.Conn with the NewConn function, to be
+used with libraries that expect a network connection. In this case timeouts
+should be set through the net.Conn deadlines:
+
+	conn := serial.NewConn(port)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+On unix the port also implements the syscall.Conn interface, that gives access
+to the file descriptor of the port, for example to issue ioctls that are not
+supported by this library:
+
+	conn, err := port.(syscall.Conn).SyscallConn()
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn.Control(func(fd uintptr) {
+		// ...
+	})
+
 If a port is a virtual USB-CDC serial port (for example an USB-to-RS232
 cable or a microcontroller development board) is possible to retrieve
 the USB metadata, like VID/PID or USB Serial Number, with the
@@ -17,11 +17,23 @@ type PortDetails struct {
 	PID          string
 	SerialNumber string
 
-	// Manufacturer string
+	// Manufacturer is the manufacturer string reported by the USB device, it
+	// may be not always available.
+	Manufacturer string
 
 	// Product is an OS-dependent string that describes the serial port, it may
 	// be not always available and it may be different across OS.
 	Product string
+
+	// InterfaceNumber is the number of the USB interface of the serial port
+	// in hex (for example "00"), useful to select a port of a composite
+	// device. It may be not always available.
+	InterfaceNumber string
+
+	// Location is an OS-dependent string that identifies the physical USB
+	// port where the device is connected (for example "1-1.4" on Linux), it
+	// may be not always available.
+	Location string
 }
 
 // GetDetailedPortsList retrieve ports details like USB VID/PID.
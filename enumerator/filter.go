@@ -0,0 +1,80 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package enumerator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PortFilter selects serial ports by their details, the empty fields match
+// any port. VID, PID and InterfaceNumber are compared ignoring the case, and
+// Product matches if it's contained in the product string of the port. If any
+// field is set only USB ports are selected.
+//
+// PortFilter implements serial.PortMatcher so it can be used with
+// serial.OpenMatching:
+//
+//	port, err := serial.OpenMatching(&enumerator.PortFilter{VID: "0403", SerialNumber: "A50285BI"}, mode)
+type PortFilter struct {
+	VID             string
+	PID             string
+	SerialNumber    string
+	Product         string
+	InterfaceNumber string
+	Location        string
+}
+
+// Match returns true if the port matches the filter.
+func (f *PortFilter) Match(port *PortDetails) bool {
+	if *f == (PortFilter{}) {
+		return true
+	}
+	return port.IsUSB &&
+		(f.VID == "" || strings.EqualFold(f.VID, port.VID)) &&
+		(f.PID == "" || strings.EqualFold(f.PID, port.PID)) &&
+		(f.SerialNumber == "" || f.SerialNumber == port.SerialNumber) &&
+		(f.Product == "" || strings.Contains(port.Product, f.Product)) &&
+		(f.InterfaceNumber == "" || strings.EqualFold(f.InterfaceNumber, port.InterfaceNumber)) &&
+		(f.Location == "" || f.Location == port.Location)
+}
+
+// MatchingPorts returns the names of the ports, listed by
+// GetDetailedPortsList, that match the filter.
+func (f *PortFilter) MatchingPorts() ([]string, error) {
+	ports, err := GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, port := range ports {
+		if f.Match(port) {
+			res = append(res, port.Name)
+		}
+	}
+	return res, nil
+}
+
+// String returns a description of the filter.
+func (f *PortFilter) String() string {
+	var res []string
+	add := func(name, value string) {
+		if value != "" {
+			res = append(res, fmt.Sprintf("%s=%q", name, value))
+		}
+	}
+	add("VID", f.VID)
+	add("PID", f.PID)
+	add("SerialNumber", f.SerialNumber)
+	add("Product", f.Product)
+	add("InterfaceNumber", f.InterfaceNumber)
+	add("Location", f.Location)
+	if len(res) == 0 {
+		return "any port"
+	}
+	return strings.Join(res, " ")
+}
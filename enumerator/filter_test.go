@@ -0,0 +1,49 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package enumerator
+
+import (
+	"testing"
+)
+
+func TestPortFilter(t *testing.T) {
+	ftdi := &PortDetails{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A50285BI", Product: "FT232R USB UART", InterfaceNumber: "00", Location: "1-1.4"}
+	composite := &PortDetails{Name: "/dev/ttyACM1", IsUSB: true, VID: "03EB", PID: "2111", InterfaceNumber: "01", Location: "1-2"}
+	builtin := &PortDetails{Name: "/dev/ttyS0"}
+
+	tests := []struct {
+		filter  PortFilter
+		matches []*PortDetails
+	}{
+		{PortFilter{}, []*PortDetails{ftdi, composite, builtin}},
+		{PortFilter{VID: "0403"}, []*PortDetails{ftdi}},
+		{PortFilter{VID: "03eb", PID: "2111"}, []*PortDetails{composite}},
+		{PortFilter{VID: "03EB", InterfaceNumber: "00"}, nil},
+		{PortFilter{SerialNumber: "A50285BI"}, []*PortDetails{ftdi}},
+		{PortFilter{SerialNumber: "a50285bi"}, nil},
+		{PortFilter{Product: "FT232R"}, []*PortDetails{ftdi}},
+		{PortFilter{Location: "1-2"}, []*PortDetails{composite}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter.String(), func(t *testing.T) {
+			var matches []*PortDetails
+			for _, port := range []*PortDetails{ftdi, composite, builtin} {
+				if tt.filter.Match(port) {
+					matches = append(matches, port)
+				}
+			}
+			if len(matches) != len(tt.matches) {
+				t.Fatalf("got %d matches, expected %d", len(matches), len(tt.matches))
+			}
+			for i := range matches {
+				if matches[i] != tt.matches[i] {
+					t.Errorf("got %s, expected %s", matches[i].Name, tt.matches[i].Name)
+				}
+			}
+		})
+	}
+}
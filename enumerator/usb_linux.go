@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"go.bug.st/serial"
 )
@@ -52,10 +53,10 @@ func nativeGetPortDetails(portPath string) (*PortDetails, error) {
 	result := &PortDetails{Name: portPath}
 	switch subSystem {
 	case "usb-serial":
-		err := parseUSBSysFS(filepath.Dir(filepath.Dir(realDevicePath)), result)
+		err := parseUSBSysFS(filepath.Dir(filepath.Dir(realDevicePath)), filepath.Dir(realDevicePath), result)
 		return result, err
 	case "usb":
-		err := parseUSBSysFS(filepath.Dir(realDevicePath), result)
+		err := parseUSBSysFS(filepath.Dir(realDevicePath), realDevicePath, result)
 		return result, err
 	// TODO: other cases?
 	default:
@@ -63,7 +64,7 @@ func nativeGetPortDetails(portPath string) (*PortDetails, error) {
 	}
 }
 
-func parseUSBSysFS(usbDevicePath string, details *PortDetails) error {
+func parseUSBSysFS(usbDevicePath, usbInterfacePath string, details *PortDetails) error {
 	vid, err := readLine(filepath.Join(usbDevicePath, "idVendor"))
 	if err != nil {
 		return err
@@ -76,21 +77,25 @@ func parseUSBSysFS(usbDevicePath string, details *PortDetails) error {
 	if err != nil {
 		return err
 	}
-	//manufacturer, err := readLine(filepath.Join(usbDevicePath, "manufacturer"))
-	//if err != nil {
-	//	return err
-	//}
-	//product, err := readLine(filepath.Join(usbDevicePath, "product"))
-	//if err != nil {
-	//	return err
-	//}
+	interfaceNumber, err := readLine(filepath.Join(usbInterfacePath, "bInterfaceNumber"))
+	if err != nil {
+		return err
+	}
+	// The strings are optional: they are missing if the device doesn't
+	// provide them and reading them may fail if the device doesn't answer,
+	// in both cases they are left empty.
+	manufacturer, _ := readLine(filepath.Join(usbDevicePath, "manufacturer"))
+	product, _ := readLine(filepath.Join(usbDevicePath, "product"))
 
 	details.IsUSB = true
 	details.VID = vid
 	details.PID = pid
 	details.SerialNumber = serial
-	//details.Manufacturer = manufacturer
-	//details.Product = product
+	details.InterfaceNumber = interfaceNumber
+	// The name of the device is its position in the USB tree (bus-port.port...)
+	details.Location = filepath.Base(usbDevicePath)
+	details.Manufacturer = strings.TrimSpace(manufacturer)
+	details.Product = strings.TrimSpace(product)
 	return nil
 }
 
@@ -0,0 +1,53 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package enumerator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseUSBSysFS(t *testing.T) {
+	write := func(dir string, attrs map[string]string) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range attrs {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	device := filepath.Join(t.TempDir(), "1-1.4")
+	iface := filepath.Join(device, "1-1.4:1.0")
+	write(device, map[string]string{"idVendor": "0403", "idProduct": "6001", "serial": "A50285BI", "manufacturer": "FTDI", "product": "FT232R USB UART "})
+	write(iface, map[string]string{"bInterfaceNumber": "00"})
+	details := &PortDetails{}
+	if err := parseUSBSysFS(device, iface, details); err != nil {
+		t.Fatal(err)
+	}
+	expected := PortDetails{IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A50285BI", Manufacturer: "FTDI", Product: "FT232R USB UART", InterfaceNumber: "00", Location: "1-1.4"}
+	if *details != expected {
+		t.Errorf("got %+v, expected %+v", *details, expected)
+	}
+
+	// A device without strings
+	device = filepath.Join(t.TempDir(), "2-1")
+	iface = filepath.Join(device, "2-1:1.0")
+	write(device, map[string]string{"idVendor": "2341", "idProduct": "0043"})
+	write(iface, map[string]string{"bInterfaceNumber": "00"})
+	details = &PortDetails{}
+	if err := parseUSBSysFS(device, iface, details); err != nil {
+		t.Fatal(err)
+	}
+	expected = PortDetails{IsUSB: true, VID: "2341", PID: "0043", InterfaceNumber: "00", Location: "2-1"}
+	if *details != expected {
+		t.Errorf("got %+v, expected %+v", *details, expected)
+	}
+}
@@ -15,6 +15,10 @@ import (
 	"golang.org/x/sys/windows"
 )
 
+// interfaceNumberRegexp matches the interface of a composite device in the
+// device ID.
+var interfaceNumberRegexp = regexp.MustCompile(`&MI_(..)`)
+
 func parseDeviceID(deviceID string, details *PortDetails) {
 	// Windows stock USB-CDC driver
 	if len(deviceID) >= 3 && deviceID[:3] == "USB" {
@@ -29,6 +33,10 @@ func parseDeviceID(deviceID string, details *PortDetails) {
 		if len(re[0]) >= 4 {
 			details.SerialNumber = re[0][4]
 		}
+		// Interface of a composite device
+		if mi := interfaceNumberRegexp.FindStringSubmatch(deviceID); mi != nil {
+			details.InterfaceNumber = mi[1]
+		}
 		return
 	}
 
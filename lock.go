@@ -0,0 +1,26 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "fmt"
+
+// LockError is the cause of the PortBusy error returned when the port is
+// locked by another process (see WithLockFile and WithFlock).
+type LockError struct {
+	LockFile string // The lock file, or empty if the port is locked with flock
+	PID      int    // The process holding the lock, or 0 if unknown
+}
+
+func (e *LockError) Error() string {
+	if e.LockFile == "" {
+		return "port locked by another process"
+	}
+	if e.PID == 0 {
+		return fmt.Sprintf("port locked by another process (%s)", e.LockFile)
+	}
+	return fmt.Sprintf("port locked by process %d (%s)", e.PID, e.LockFile)
+}
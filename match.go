@@ -0,0 +1,88 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PortMatcher selects serial ports, for example by the properties of the
+// USB device. The enumerator package provides an implementation
+// (enumerator.PortFilter) that selects the ports by USB VID, PID, serial
+// number, etc.
+type PortMatcher interface {
+	// MatchingPorts returns the names of the ports currently available that
+	// match.
+	MatchingPorts() ([]string, error)
+}
+
+// matchPollInterval is the interval between two searches of
+// WaitMatchingPort.
+var matchPollInterval = 250 * time.Millisecond
+
+// OpenMatching opens the only serial port selected by matcher, using the
+// specified mode. It returns a PortNotFound error if no port matches and a
+// MultiplePortsFound error if more than one port matches.
+func OpenMatching(matcher PortMatcher, mode *Mode) (Port, error) {
+	portName, err := matchPort(matcher)
+	if err != nil {
+		return nil, err
+	}
+	return Open(portName, mode)
+}
+
+// OpenMatchingContext is like OpenMatching but, if no port matches, it waits
+// until a matching port appears or ctx is done.
+func OpenMatchingContext(ctx context.Context, matcher PortMatcher, mode *Mode) (Port, error) {
+	portName, err := WaitMatchingPort(ctx, matcher)
+	if err != nil {
+		return nil, err
+	}
+	return Open(portName, mode)
+}
+
+// WaitMatchingPort waits until a port selected by matcher is available and
+// returns its name. If ctx is done before, a PortNotFound error wrapping the
+// error of ctx is returned. If more than one port matches a
+// MultiplePortsFound error is returned immediately.
+func WaitMatchingPort(ctx context.Context, matcher PortMatcher) (string, error) {
+	ticker := time.NewTicker(matchPollInterval)
+	defer ticker.Stop()
+	for {
+		portName, err := matchPort(matcher)
+		if err == nil {
+			return portName, nil
+		}
+		if !errors.Is(err, ErrPortNotFound) {
+			return "", err
+		}
+		select {
+		case <-ctx.Done():
+			return "", withOp(&PortError{code: PortNotFound, causedBy: fmt.Errorf("no port matches %v: %w", matcher, ctx.Err())}, "open", "")
+		case <-ticker.C:
+		}
+	}
+}
+
+func matchPort(matcher PortMatcher) (string, error) {
+	ports, err := matcher.MatchingPorts()
+	if err != nil {
+		return "", err
+	}
+	switch len(ports) {
+	case 0:
+		return "", withOp(&PortError{code: PortNotFound, causedBy: fmt.Errorf("no port matches %v", matcher)}, "open", "")
+	case 1:
+		return ports[0], nil
+	default:
+		return "", withOp(&PortError{code: MultiplePortsFound, causedBy: fmt.Errorf("%s match %v", strings.Join(ports, ", "), matcher)}, "open", "")
+	}
+}
@@ -0,0 +1,178 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import "time"
+
+// Option is a setting used to open a serial port with OpenWithOptions.
+type Option func(*openOptions)
+
+type openOptions struct {
+	mode             *Mode
+	runtimePoller    bool
+	keepTermSettings bool
+	restoreOnClose   bool
+	keepModemLines   bool
+	hangupOnClose    *bool // nil to leave HUPCL as it is
+	exclusiveAccess  bool
+	nonblockingOpen  bool
+	readTimeout      time.Duration
+	lockFile         bool
+	lockDir          string
+	flock            bool
+
+	platformOptions
+}
+
+func newOpenOptions(opts []Option) *openOptions {
+	options := &openOptions{
+		mode:            &Mode{},
+		exclusiveAccess: true,
+		nonblockingOpen: true,
+		readTimeout:     NoTimeout,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithMode sets the mode of the port, if not specified the default Mode
+// (9600 baud, 8 data bits, no parity and 1 stop bit) is used.
+func WithMode(mode *Mode) Option {
+	return func(o *openOptions) {
+		o.mode = mode
+	}
+}
+
+// WithRuntimePoller makes the port wait for I/O through the poller of the Go
+// runtime (the same used by os.File and the net package) instead of a select
+// loop: a blocked Read or Write parks its goroutine without holding an OS
+// thread. This is useful for processes that keep many ports open.
+//
+// This option is supported on unix, if the runtime poller can't handle the
+// device (for example on darwin, where kqueue may not support ttys) opening
+// the port fails with a FunctionNotImplemented error. It has no effect on
+// Windows, that always uses overlapped I/O.
+func WithRuntimePoller() Option {
+	return func(o *openOptions) {
+		o.runtimePoller = true
+	}
+}
+
+// WithKeepTermSettings leaves the current settings of the port (speed, data
+// format, flow control and, on unix, line discipline...) untouched when the
+// port is opened, instead of putting it in raw mode and applying the Mode.
+// Only the InitialStatusBits of the Mode are applied, if set (see also
+// WithKeepModemLines). Together with WithKeepModemLines it allows to attach
+// to a running device without reconfiguring it.
+func WithKeepTermSettings() Option {
+	return func(o *openOptions) {
+		o.keepTermSettings = true
+	}
+}
+
+// WithRestoreOnClose saves the settings of the tty and the status of the
+// DTR and RTS lines when the port is opened, and restores them when the port
+// is closed. This is useful for consoles and ports shared with other tools,
+// that would otherwise be left in raw mode.
+//
+// This option is supported on unix only, it has no effect on Windows.
+func WithRestoreOnClose() Option {
+	return func(o *openOptions) {
+		o.restoreOnClose = true
+	}
+}
+
+// WithKeepModemLines leaves the DTR and RTS lines as they are when the port is
+// opened, ignoring the InitialStatusBits of the Mode. This avoids to reset
+// devices, like many Arduino boards, that reboot when DTR changes.
+//
+// Note: on unix the kernel raises DTR and RTS when the tty is opened if they
+// have been dropped by the hangup at the previous close: to keep the lines up
+// between two opens the HUPCL flag must be cleared, see WithHangupOnClose.
+func WithKeepModemLines() Option {
+	return func(o *openOptions) {
+		o.keepModemLines = true
+	}
+}
+
+// WithHangupOnClose sets (or clears) the HUPCL flag of the tty: when it's set
+// the kernel drops DTR and RTS after the last process closing the port. If
+// this option is not used the current setting of the tty is left untouched.
+//
+// This option is supported on unix only, it has no effect on Windows.
+func WithHangupOnClose(hangup bool) Option {
+	return func(o *openOptions) {
+		o.hangupOnClose = &hangup
+	}
+}
+
+// WithExclusiveAccess sets if the port is opened for exclusive access (the
+// default): on unix further opens of the tty fail with a PortBusy error, even
+// from other processes, until the port is closed. Root can still open it.
+//
+// This option has no effect on Windows, where ports are always opened for
+// exclusive access.
+func WithExclusiveAccess(exclusive bool) Option {
+	return func(o *openOptions) {
+		o.exclusiveAccess = exclusive
+	}
+}
+
+// WithNonblockingOpen sets if the device is opened in non-blocking mode (the
+// default). With a blocking open the call waits until the carrier (DCD) is
+// detected, unless the CLOCAL flag of the tty is set. After the open the port
+// is always configured for non-blocking I/O.
+//
+// This option is supported on unix only, it has no effect on Windows.
+func WithNonblockingOpen(nonblocking bool) Option {
+	return func(o *openOptions) {
+		o.nonblockingOpen = nonblocking
+	}
+}
+
+// WithReadTimeout sets the initial read timeout of the port, as if it was set
+// with SetReadTimeout. If not specified Read blocks until data is received.
+func WithReadTimeout(t time.Duration) Option {
+	return func(o *openOptions) {
+		o.readTimeout = t
+	}
+}
+
+// WithLockFile creates a UUCP-style lock file (LCK..<device name>) in the
+// directory dir before opening the port, and removes it when the port is
+// closed. This is the convention used by many tools, like minicom or
+// ModemManager, that ignore the exclusive access of the tty. If dir is empty
+// the default directory of the system is used (/var/lock on Linux).
+//
+// If the port is locked by a running process the open fails with a PortBusy
+// error caused by a *LockError, that reports the PID of the process. Stale
+// lock files, left by processes that are no longer running, are removed. A
+// lock file that doesn't contain a valid PID (in ASCII or binary form) is
+// never removed, the open fails with a *LockError with an unknown PID.
+//
+// This option is supported on unix only, it has no effect on Windows.
+func WithLockFile(dir string) Option {
+	return func(o *openOptions) {
+		o.lockFile = true
+		o.lockDir = dir
+	}
+}
+
+// WithFlock places an exclusive flock on the tty while the port is open, as
+// done by tools like picocom. If the tty is already locked the open fails with
+// a PortBusy error caused by a *LockError. flock doesn't report the owner of
+// the lock, so the PID of the LockError is 0 (unknown) and its LockFile is
+// empty.
+//
+// This option is supported on unix only, it has no effect on Windows.
+func WithFlock() Option {
+	return func(o *openOptions) {
+		o.flock = true
+	}
+}
@@ -0,0 +1,27 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build linux || darwin || freebsd || openbsd
+
+package serial
+
+import "golang.org/x/sys/unix"
+
+type platformOptions struct {
+	termSettingsHook func(settings *unix.Termios) error
+}
+
+// WithTermSettingsHook sets a function that can change the termios settings
+// of the tty before they are applied when the port is opened. The hook is
+// called after the port has been put in raw mode (unless WithKeepTermSettings
+// is used) and before the Mode is applied, so the settings controlled by the
+// Mode are overwritten. If the hook returns an error the open fails with an
+// InvalidSerialPort error caused by it.
+func WithTermSettingsHook(hook func(settings *unix.Termios) error) Option {
+	return func(o *openOptions) {
+		o.termSettingsHook = hook
+	}
+}
@@ -32,6 +32,9 @@ func main() {
 	}
 	for _, port := range ports {
 		fmt.Printf("Port: %s\n", port.Name)
+		if port.Manufacturer != "" {
+			fmt.Printf("   Manufacturer: %s\n", port.Manufacturer)
+		}
 		if port.Product != "" {
 			fmt.Printf("   Product Name: %s\n", port.Product)
 		}
@@ -0,0 +1,515 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Package reconnect provides a serial.Port that survives the disconnection
+// of the device: when the device vanishes it's searched again (it may come
+// back with a different name, for example as /dev/ttyUSB1 instead of
+// /dev/ttyUSB0) and reopened with the same settings.
+//
+//	port, err := reconnect.Open(&reconnect.Config{
+//		Resolver: reconnect.BySerialNumber("A50285BI"),
+//		Mode:     &serial.Mode{BaudRate: 115200},
+//	})
+package reconnect
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// ErrDisconnected is returned by the operations on a Port while the device is
+// disconnected, if the policy is FailWhileDisconnected. It matches
+// serial.ErrDeviceRemoved with errors.Is.
+var ErrDisconnected = fmt.Errorf("device disconnected: %w", serial.ErrDeviceRemoved)
+
+// openPort opens the devices, it's replaced by the tests.
+var openPort = serial.OpenWithOptions
+
+// Resolver returns the current name of the device to open.
+type Resolver func() (string, error)
+
+// ByName returns a Resolver for the port with the given name.
+func ByName(name string) Resolver {
+	return func() (string, error) {
+		return name, nil
+	}
+}
+
+// BySerialNumber returns a Resolver for the USB device with the given serial
+// number, searched with enumerator.GetDetailedPortsList.
+func BySerialNumber(serialNumber string) Resolver {
+	return func() (string, error) {
+		ports, err := enumerator.GetDetailedPortsList()
+		if err != nil {
+			return "", err
+		}
+		for _, port := range ports {
+			if port.IsUSB && port.SerialNumber == serialNumber {
+				return port.Name, nil
+			}
+		}
+		return "", serial.ErrPortNotFound
+	}
+}
+
+// Policy selects the behaviour of Read and Write while the device is
+// disconnected.
+type Policy int
+
+const (
+	// BlockWhileDisconnected makes Read and Write wait until the device is
+	// reconnected (Read still returns when the read timeout expires).
+	BlockWhileDisconnected Policy = iota
+	// FailWhileDisconnected makes Read and Write return ErrDisconnected.
+	FailWhileDisconnected
+)
+
+// Config is the configuration of a Port.
+type Config struct {
+	Resolver Resolver        // Finds the device to open (required)
+	Mode     *serial.Mode    // The mode of the port, can be changed later with SetMode
+	Options  []serial.Option // Additional options used to open the device
+	Policy   Policy          // Behaviour of Read and Write while disconnected
+
+	// Delay between two reconnection attempts: it starts from MinBackoff
+	// and it's doubled after each failure up to MaxBackoff (if zero they
+	// default to 100 milliseconds and 5 seconds).
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnConnect is called when the device is reconnected, with its name.
+	OnConnect func(portName string)
+	// OnDisconnect is called when the device is disconnected, with the
+	// error that revealed the disconnection.
+	OnDisconnect func(err error)
+}
+
+// Port is a serial.Port that reopens its device when it's disconnected. The
+// mode, the status of DTR and RTS and the timeouts set on the Port are applied
+// again to the reopened device. The other functions fail with ErrDisconnected
+// while the device is disconnected.
+//
+// If the device can't be reopened because of its settings (for example a mode
+// saved with SetMode while disconnected that isn't supported by the device),
+// Read and Write return the error until the device is reopened: the settings
+// can be fixed in the meantime.
+type Port struct {
+	config Config
+
+	mu           sync.Mutex
+	port         serial.Port   // nil while disconnected
+	name         string        // the name of the last device connected
+	connected    chan struct{} // closed when the device is connected
+	reopenErr    error         // the error of the last reopen, if caused by the settings
+	reopenFailed chan struct{} // closed when reopenErr is set
+	done         chan struct{} // closed by Close
+	closed       bool
+	mode         serial.Mode
+	dtr, rts     *bool
+	readTimeout  time.Duration
+	writeTimeout *time.Duration
+	wg           sync.WaitGroup
+}
+
+// Open opens the device found by the Resolver of config. The device must be
+// available when the Port is opened.
+func Open(config *Config) (*Port, error) {
+	port := &Port{
+		config:      *config,
+		done:        make(chan struct{}),
+		readTimeout: serial.NoTimeout,
+	}
+	if port.config.Mode != nil {
+		port.mode = *port.config.Mode
+	}
+	if port.config.MinBackoff <= 0 {
+		port.config.MinBackoff = 100 * time.Millisecond
+	}
+	if port.config.MaxBackoff < port.config.MinBackoff {
+		port.config.MaxBackoff = max(5*time.Second, port.config.MinBackoff)
+	}
+
+	p, name, err := port.connect()
+	if err != nil {
+		return nil, err
+	}
+	port.port = p
+	port.name = name
+	port.connected = make(chan struct{})
+	close(port.connected)
+	return port, nil
+}
+
+// connect resolves and opens the device with the current settings.
+func (port *Port) connect() (serial.Port, string, error) {
+	name, err := port.config.Resolver()
+	if err != nil {
+		return nil, "", err
+	}
+
+	port.mu.Lock()
+	mode := port.mode
+	if port.dtr != nil || port.rts != nil {
+		bits := serial.ModemOutputBits{DTR: true, RTS: true}
+		if mode.InitialStatusBits != nil {
+			bits = *mode.InitialStatusBits
+		}
+		if port.dtr != nil {
+			bits.DTR = *port.dtr
+		}
+		if port.rts != nil {
+			bits.RTS = *port.rts
+		}
+		mode.InitialStatusBits = &bits
+	}
+	opts := append([]serial.Option{}, port.config.Options...)
+	opts = append(opts, serial.WithMode(&mode), serial.WithReadTimeout(port.readTimeout))
+	writeTimeout := port.writeTimeout
+	port.mu.Unlock()
+
+	p, err := openPort(name, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	if writeTimeout != nil {
+		if err := setWriteTimeout(p, *writeTimeout); err != nil {
+			p.Close()
+			return nil, "", err
+		}
+	}
+	return p, name, nil
+}
+
+func setWriteTimeout(p serial.Port, t time.Duration) error {
+	setter, ok := p.(serial.WriteTimeoutSetter)
+	if !ok {
+		return serial.ErrFunctionNotImplemented
+	}
+	return setter.SetWriteTimeout(t)
+}
+
+// reconnect tries to reopen the device until it succeeds or the Port is
+// closed.
+func (port *Port) reconnect() {
+	defer port.wg.Done()
+	backoff := port.config.MinBackoff
+	for {
+		select {
+		case <-port.done:
+			return
+		case <-time.After(backoff):
+		}
+		p, name, err := port.connect()
+		if err != nil {
+			if isSettingsError(err) {
+				port.mu.Lock()
+				if port.reopenErr == nil {
+					close(port.reopenFailed)
+				}
+				port.reopenErr = fmt.Errorf("error reopening the device: %w", err)
+				port.mu.Unlock()
+			}
+			backoff = min(backoff*2, port.config.MaxBackoff)
+			continue
+		}
+
+		port.mu.Lock()
+		if port.closed {
+			port.mu.Unlock()
+			p.Close()
+			return
+		}
+		port.port = p
+		port.name = name
+		port.reopenErr = nil
+		close(port.connected)
+		port.mu.Unlock()
+		if port.config.OnConnect != nil {
+			port.config.OnConnect(name)
+		}
+		return
+	}
+}
+
+// current returns the connected device, waiting for the reconnection if wait
+// is true and the policy allows it. The wait is limited by timeout, if not
+// serial.NoTimeout: a nil port and no error are returned when it expires. If
+// wait is true and the device can't be reopened with the current settings the
+// reopen error is returned.
+func (port *Port) current(wait bool, timeout time.Duration) (serial.Port, error) {
+	port.mu.Lock()
+	p, connected, closed := port.port, port.connected, port.closed
+	reopenErr, reopenFailed := port.reopenErr, port.reopenFailed
+	port.mu.Unlock()
+	switch {
+	case closed:
+		return nil, serial.ErrPortClosed
+	case p != nil:
+		return p, nil
+	case wait && reopenErr != nil:
+		return nil, reopenErr
+	case !wait || port.config.Policy == FailWhileDisconnected:
+		return nil, ErrDisconnected
+	}
+
+	var expired <-chan time.Time
+	if timeout != serial.NoTimeout {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-connected:
+		return port.current(wait, timeout)
+	case <-reopenFailed:
+		return port.current(wait, timeout)
+	case <-port.done:
+		return nil, serial.ErrPortClosed
+	case <-expired:
+		return nil, nil
+	}
+}
+
+// check handles the error returned by an operation on the device p: if it
+// reveals a disconnection the device is closed and the reconnection started.
+// It returns true if the device has been disconnected.
+func (port *Port) check(p serial.Port, err error) bool {
+	if !isDisconnection(err) {
+		return false
+	}
+	port.mu.Lock()
+	if port.closed || port.port != p {
+		// Already handled (or closed on purpose)
+		closed := port.closed
+		port.mu.Unlock()
+		return !closed
+	}
+	port.port = nil
+	port.connected = make(chan struct{})
+	port.reopenErr = nil
+	port.reopenFailed = make(chan struct{})
+	port.wg.Add(1)
+	port.mu.Unlock()
+
+	p.Close()
+	if port.config.OnDisconnect != nil {
+		port.config.OnDisconnect(err)
+	}
+	go port.reconnect()
+	return true
+}
+
+// isDisconnection returns true if err reveals that the device has been
+// disconnected. A PortClosed error can't come from a port that is still open:
+// the device has been closed by the OS.
+func isDisconnection(err error) bool {
+	return errors.Is(err, serial.ErrDeviceRemoved) || errors.Is(err, serial.ErrPortClosed)
+}
+
+// isSettingsError returns true if err has been caused by a setting of the
+// port that isn't supported by the device.
+func isSettingsError(err error) bool {
+	for _, target := range []error{
+		serial.ErrInvalidSpeed, serial.ErrInvalidDataBits, serial.ErrInvalidParity,
+		serial.ErrInvalidStopBits, serial.ErrInvalidFlowControl, serial.ErrInvalidTimeoutValue,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads from the device, see Policy for the behaviour while the device
+// is disconnected.
+func (port *Port) Read(p []byte) (int, error) {
+	port.mu.Lock()
+	timeout := port.readTimeout
+	port.mu.Unlock()
+	for {
+		dev, err := port.current(true, timeout)
+		if err != nil {
+			return 0, err
+		}
+		if dev == nil {
+			// Timeout while waiting for the reconnection
+			return 0, nil
+		}
+		n, err := dev.Read(p)
+		if !port.check(dev, err) {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Write writes to the device, see Policy for the behaviour while the device
+// is disconnected. The data not written when the device is disconnected is
+// sent to the reconnected device.
+func (port *Port) Write(p []byte) (int, error) {
+	written := 0
+	for {
+		dev, err := port.current(true, serial.NoTimeout)
+		if err != nil {
+			return written, err
+		}
+		n, err := dev.Write(p[written:])
+		written += n
+		if !port.check(dev, err) {
+			return written, err
+		}
+		if written == len(p) {
+			return written, nil
+		}
+	}
+}
+
+// do runs f on the connected device, without waiting if it's disconnected.
+func (port *Port) do(f func(dev serial.Port) error) error {
+	dev, err := port.current(false, serial.NoTimeout)
+	if err != nil {
+		return err
+	}
+	err = f(dev)
+	if port.check(dev, err) {
+		return ErrDisconnected
+	}
+	return err
+}
+
+// SetMode sets the mode of the device, the mode is applied again when the
+// device is reconnected. While the device is disconnected the mode is only
+// saved: if it isn't supported by the device, Read and Write return the error
+// of the reopen.
+func (port *Port) SetMode(mode *serial.Mode) error {
+	err := port.do(func(dev serial.Port) error { return dev.SetMode(mode) })
+	if err == nil || errors.Is(err, ErrDisconnected) {
+		port.mu.Lock()
+		port.mode = *mode
+		port.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// SetDTR sets the DTR line, its status is applied again when the device is
+// reconnected. While the device is disconnected the status is only saved.
+func (port *Port) SetDTR(dtr bool) error {
+	err := port.do(func(dev serial.Port) error { return dev.SetDTR(dtr) })
+	if err == nil || errors.Is(err, ErrDisconnected) {
+		port.mu.Lock()
+		port.dtr = &dtr
+		port.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// SetRTS sets the RTS line, its status is applied again when the device is
+// reconnected. While the device is disconnected the status is only saved.
+func (port *Port) SetRTS(rts bool) error {
+	err := port.do(func(dev serial.Port) error { return dev.SetRTS(rts) })
+	if err == nil || errors.Is(err, ErrDisconnected) {
+		port.mu.Lock()
+		port.rts = &rts
+		port.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// SetReadTimeout sets the read timeout, it also limits the time Read waits
+// for the reconnection of the device.
+func (port *Port) SetReadTimeout(t time.Duration) error {
+	err := port.do(func(dev serial.Port) error { return dev.SetReadTimeout(t) })
+	if err == nil || errors.Is(err, ErrDisconnected) {
+		port.mu.Lock()
+		port.readTimeout = t
+		port.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// SetWriteTimeout sets the write timeout, if supported by the device (see
+// serial.WriteTimeoutSetter).
+func (port *Port) SetWriteTimeout(t time.Duration) error {
+	err := port.do(func(dev serial.Port) error { return setWriteTimeout(dev, t) })
+	if err == nil || errors.Is(err, ErrDisconnected) {
+		port.mu.Lock()
+		port.writeTimeout = &t
+		port.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// PortName returns the name of the device, the last one connected if it's
+// disconnected.
+func (port *Port) PortName() string {
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	return port.name
+}
+
+// Drain waits until all the data written is sent.
+func (port *Port) Drain() error {
+	return port.do(serial.Port.Drain)
+}
+
+// ResetInputBuffer discards the data received and not yet read.
+func (port *Port) ResetInputBuffer() error {
+	return port.do(serial.Port.ResetInputBuffer)
+}
+
+// ResetOutputBuffer discards the data written and not yet sent.
+func (port *Port) ResetOutputBuffer() error {
+	return port.do(serial.Port.ResetOutputBuffer)
+}
+
+// GetModemStatusBits returns the status of the modem input lines.
+func (port *Port) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	var status *serial.ModemStatusBits
+	err := port.do(func(dev serial.Port) error {
+		var err error
+		status, err = dev.GetModemStatusBits()
+		return err
+	})
+	return status, err
+}
+
+// Break sends a break for the given time.
+func (port *Port) Break(t time.Duration) error {
+	return port.do(func(dev serial.Port) error { return dev.Break(t) })
+}
+
+// Close closes the device and stops the reconnection.
+func (port *Port) Close() error {
+	port.mu.Lock()
+	if port.closed {
+		port.mu.Unlock()
+		return nil
+	}
+	port.closed = true
+	close(port.done)
+	dev := port.port
+	port.port = nil
+	port.mu.Unlock()
+
+	var err error
+	if dev != nil {
+		err = dev.Close()
+	}
+	port.wg.Wait()
+	return err
+}
@@ -0,0 +1,205 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package reconnect
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// device simulates a device that can be unplugged and plugged again with a
+// different name.
+type device struct {
+	mu     sync.Mutex
+	master serial.Port
+	name   string
+}
+
+func (d *device) plug(t *testing.T) {
+	master, name, err := serial.OpenPseudoTerminal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	d.master, d.name = master, name
+	d.mu.Unlock()
+}
+
+func (d *device) unplug() {
+	d.mu.Lock()
+	d.master.Close()
+	d.mu.Unlock()
+}
+
+func (d *device) resolve() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.name, nil
+}
+
+func (d *device) write(t *testing.T, data string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.master.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, port *Port) string {
+	buff := make([]byte, 100)
+	n, err := port.Read(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buff[:n])
+}
+
+func TestReconnect(t *testing.T) {
+	dev := &device{}
+	dev.plug(t)
+	defer dev.unplug()
+
+	connected := make(chan string, 1)
+	disconnected := make(chan error, 1)
+	port, err := Open(&Config{
+		Resolver:     dev.resolve,
+		Mode:         &serial.Mode{BaudRate: 115200},
+		MinBackoff:   10 * time.Millisecond,
+		OnConnect:    func(name string) { connected <- name },
+		OnDisconnect: func(err error) { disconnected <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	dev.write(t, "hello")
+	if data := read(t, port); data != "hello" {
+		t.Fatalf("read %q, expected %q", data, "hello")
+	}
+
+	// Read blocks until the device is back
+	go func() {
+		if err := <-disconnected; !errors.Is(err, serial.ErrDeviceRemoved) {
+			t.Errorf("disconnected with %v, expected DeviceRemoved", err)
+		}
+		dev.plug(t)
+		name, _ := dev.resolve()
+		if n := <-connected; n != name {
+			t.Errorf("reconnected to %s, expected %s", n, name)
+		}
+		dev.write(t, "world")
+	}()
+	dev.unplug()
+	if data := read(t, port); data != "world" {
+		t.Fatalf("read %q, expected %q", data, "world")
+	}
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	dev := &device{}
+	dev.plug(t)
+
+	disconnected := make(chan error, 1)
+	port, err := Open(&Config{
+		Resolver:     dev.resolve,
+		Policy:       FailWhileDisconnected,
+		MinBackoff:   time.Hour,
+		OnDisconnect: func(err error) { disconnected <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	dev.unplug()
+	if _, err := port.Read(make([]byte, 10)); !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("read returned %v, expected ErrDisconnected", err)
+	}
+	<-disconnected
+	if _, err := port.Write([]byte("x")); !errors.Is(err, ErrDisconnected) || !errors.Is(err, serial.ErrDeviceRemoved) {
+		t.Fatalf("write returned %v, expected ErrDisconnected", err)
+	}
+	// The settings are saved while disconnected
+	if err := port.SetReadTimeout(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// With BlockWhileDisconnected the read timeout is honored
+	port.config.Policy = BlockWhileDisconnected
+	start := time.Now()
+	if n, err := port.Read(make([]byte, 10)); n != 0 || err != nil {
+		t.Fatalf("read returned %d, %v, expected a timeout", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("read returned after %v, expected 100ms", elapsed)
+	}
+
+	// Close unblocks a waiting read
+	port.SetReadTimeout(serial.NoTimeout)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		port.Close()
+	}()
+	if _, err := port.Read(make([]byte, 10)); !errors.Is(err, serial.ErrPortClosed) {
+		t.Fatalf("read returned %v, expected PortClosed", err)
+	}
+}
+
+func TestReconnectInvalidMode(t *testing.T) {
+	dev := &device{}
+	dev.plug(t)
+	defer dev.unplug()
+
+	connected := make(chan string, 1)
+	disconnected := make(chan error, 1)
+	port, err := Open(&Config{
+		Resolver:     dev.resolve,
+		MinBackoff:   10 * time.Millisecond,
+		MaxBackoff:   10 * time.Millisecond,
+		OnConnect:    func(name string) { connected <- name },
+		OnDisconnect: func(err error) { disconnected <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	// A read blocked while disconnected returns the reopen error, when an
+	// invalid mode is saved
+	res := make(chan error, 1)
+	go func() {
+		_, err := port.Read(make([]byte, 10))
+		res <- err
+	}()
+	dev.unplug()
+	<-disconnected
+	if err := port.SetMode(&serial.Mode{DataBits: 9}); err != nil {
+		t.Fatal(err)
+	}
+	dev.plug(t)
+	if err := <-res; !errors.Is(err, serial.ErrInvalidDataBits) {
+		t.Fatalf("read returned %v, expected InvalidDataBits", err)
+	}
+	if _, err := port.Read(make([]byte, 10)); !errors.Is(err, serial.ErrInvalidDataBits) {
+		t.Fatalf("read returned %v, expected InvalidDataBits", err)
+	}
+
+	// The mode can be fixed while disconnected
+	if err := port.SetMode(&serial.Mode{}); err != nil {
+		t.Fatal(err)
+	}
+	<-connected
+	dev.write(t, "hello")
+	if data := read(t, port); data != "hello" {
+		t.Fatalf("read %q, expected %q", data, "hello")
+	}
+}
@@ -0,0 +1,82 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package reconnect
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// fakePort is a serial.Port whose Read returns its name, or err if set.
+type fakePort struct {
+	serial.Port
+	name string
+	err  error
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	return copy(b, p.name), nil
+}
+
+func (p *fakePort) Close() error {
+	return nil
+}
+
+func TestReconnectWindowsError(t *testing.T) {
+	// The device is unplugged while open as COM3 and comes back as COM4.
+	// On Windows the read fails with ERROR_DEVICE_NOT_CONNECTED, reported
+	// as DeviceRemoved.
+	unplugged := fmt.Errorf("read COM3: %w: %w", serial.ErrDeviceRemoved, syscall.Errno(1167))
+	var mu sync.Mutex
+	names := []string{"COM3", "COM4"}
+	openPort = func(name string, opts ...serial.Option) (serial.Port, error) {
+		if name == "COM3" {
+			return &fakePort{name: name, err: unplugged}, nil
+		}
+		return &fakePort{name: name}, nil
+	}
+	defer func() { openPort = serial.OpenWithOptions }()
+
+	disconnected := make(chan error, 1)
+	port, err := Open(&Config{
+		Resolver: func() (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			name := names[0]
+			if len(names) > 1 {
+				names = names[1:]
+			}
+			return name, nil
+		},
+		MinBackoff:   10 * time.Millisecond,
+		OnDisconnect: func(err error) { disconnected <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	buff := make([]byte, 10)
+	n, err := port.Read(buff)
+	if err != nil || string(buff[:n]) != "COM4" {
+		t.Fatalf("read %q, %v, expected the data of the reconnected device", buff[:n], err)
+	}
+	if err := <-disconnected; err != unplugged {
+		t.Fatalf("disconnected with %v, expected %v", err, unplugged)
+	}
+	if addr := serial.NewConn(port).LocalAddr(); addr.String() != "COM4" {
+		t.Fatalf("unexpected address %v, expected COM4", addr)
+	}
+}
@@ -0,0 +1,188 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"sync"
+	"time"
+)
+
+// SoftRS485Config contains the configuration of the RS485 direction control
+// performed by the Port returned by NewSoftRS485Port.
+type SoftRS485Config struct {
+	// InvertRTS sets the RTS polarity: by default RTS is set while sending
+	// and cleared while receiving, if InvertRTS is true RTS is cleared while
+	// sending and set while receiving.
+	InvertRTS bool
+
+	// GuardBeforeSend is the time to wait after switching RTS to transmit and
+	// before sending the data, expressed in character times.
+	GuardBeforeSend float64
+
+	// GuardAfterSend is the time to wait after the data has been sent and
+	// before switching RTS back to receive, expressed in character times.
+	GuardAfterSend float64
+
+	// DiscardEcho enables the removal of the local echo looped back by
+	// half-duplex transceivers: the bytes written are discarded from the
+	// data received afterwards.
+	DiscardEcho bool
+}
+
+// NewSoftRS485Port returns a Port that drives the direction of an RS485
+// transceiver through the RTS line of the given port: RTS is switched to
+// transmit before each Write and switched back to receive after Drain
+// reports that all the data has been sent. This is useful for adapters
+// without RS485 support in the driver (see RS485Configurer).
+//
+// The guard times are computed from the given mode, that must match the
+// mode of the port: use the SetMode method of the returned Port to change
+// it afterwards. A nil mode or config stands for the default Mode or
+// SoftRS485Config. The returned Port doesn't implement the optional
+// interfaces of the wrapped port.
+func NewSoftRS485Port(port Port, mode *Mode, config *SoftRS485Config) (Port, error) {
+	if config == nil {
+		config = &SoftRS485Config{}
+	}
+	p := &softRS485Port{Port: port, config: *config}
+	p.setGuardTimes(mode)
+	// Start in receive mode
+	if err := port.SetRTS(p.config.InvertRTS); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+type softRS485Port struct {
+	Port
+	config SoftRS485Config
+
+	writeLock   sync.Mutex
+	guardBefore time.Duration
+	guardAfter  time.Duration
+
+	echoLock sync.Mutex
+	echo     int // bytes of local echo still to be discarded
+}
+
+// PortName returns the name of the underlying port, if it implements
+// PortNamer.
+func (p *softRS485Port) PortName() string {
+	if named, ok := p.Port.(PortNamer); ok {
+		return named.PortName()
+	}
+	return ""
+}
+
+func (p *softRS485Port) SetMode(mode *Mode) error {
+	if err := p.Port.SetMode(mode); err != nil {
+		return err
+	}
+	p.writeLock.Lock()
+	p.setGuardTimes(mode)
+	p.writeLock.Unlock()
+	return nil
+}
+
+func (p *softRS485Port) setGuardTimes(mode *Mode) {
+	charTime := characterTime(mode)
+	p.guardBefore = time.Duration(p.config.GuardBeforeSend * float64(charTime))
+	p.guardAfter = time.Duration(p.config.GuardAfterSend * float64(charTime))
+}
+
+func (p *softRS485Port) Write(b []byte) (int, error) {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	if err := p.Port.SetRTS(!p.config.InvertRTS); err != nil {
+		return 0, err
+	}
+	time.Sleep(p.guardBefore)
+
+	// The echo may be received before Write returns, so it must be
+	// accounted in advance.
+	p.addEcho(len(b))
+	n, err := p.Port.Write(b)
+	p.addEcho(n - len(b))
+	if err == nil {
+		err = p.Port.Drain()
+	}
+
+	time.Sleep(p.guardAfter)
+	if rtsErr := p.Port.SetRTS(p.config.InvertRTS); err == nil {
+		err = rtsErr
+	}
+	return n, err
+}
+
+func (p *softRS485Port) Read(b []byte) (int, error) {
+	for {
+		n, err := p.Port.Read(b)
+		if discard := p.discardEcho(n); discard > 0 {
+			n = copy(b, b[discard:n])
+			if n == 0 && err == nil {
+				// Only echo has been received, keep reading
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+func (p *softRS485Port) ResetInputBuffer() error {
+	p.echoLock.Lock()
+	defer p.echoLock.Unlock()
+	p.echo = 0
+	return p.Port.ResetInputBuffer()
+}
+
+func (p *softRS485Port) addEcho(n int) {
+	if !p.config.DiscardEcho {
+		return
+	}
+	p.echoLock.Lock()
+	p.echo += n
+	p.echoLock.Unlock()
+}
+
+// discardEcho returns how many of the n bytes just received are local echo
+func (p *softRS485Port) discardEcho(n int) int {
+	p.echoLock.Lock()
+	defer p.echoLock.Unlock()
+	discard := min(n, p.echo)
+	p.echo -= discard
+	return discard
+}
+
+// characterTime returns the time needed to transmit a single character
+// (start bit, data bits, parity bit and stop bits) with the given mode.
+func characterTime(mode *Mode) time.Duration {
+	if mode == nil {
+		mode = &Mode{}
+	}
+	baudRate, dataBits := 9600, 8
+	if mode.BaudRate != 0 {
+		baudRate = mode.BaudRate
+	}
+	if mode.DataBits != 0 {
+		dataBits = mode.DataBits
+	}
+	// Count in half bits to handle 1.5 stop bits
+	halfBits := 2 * (1 + dataBits)
+	if mode.Parity != NoParity {
+		halfBits += 2
+	}
+	switch mode.StopBits {
+	case OnePointFiveStopBits:
+		halfBits += 3
+	case TwoStopBits:
+		halfBits += 4
+	default:
+		halfBits += 2
+	}
+	return time.Duration(halfBits) * time.Second / time.Duration(2*baudRate)
+}
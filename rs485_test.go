@@ -0,0 +1,112 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// loopbackPort is a fake half-duplex Port that echoes back the data written
+type loopbackPort struct {
+	Port
+	rx    bytes.Buffer
+	calls []string
+}
+
+func (p *loopbackPort) Write(b []byte) (int, error) {
+	p.calls = append(p.calls, "write")
+	return p.rx.Write(b)
+}
+
+func (p *loopbackPort) Read(b []byte) (int, error) {
+	return p.rx.Read(b)
+}
+
+func (p *loopbackPort) Drain() error {
+	p.calls = append(p.calls, "drain")
+	return nil
+}
+
+func (p *loopbackPort) SetRTS(rts bool) error {
+	if rts {
+		p.calls = append(p.calls, "rts-on")
+	} else {
+		p.calls = append(p.calls, "rts-off")
+	}
+	return nil
+}
+
+func TestSoftRS485Port(t *testing.T) {
+	fake := &loopbackPort{}
+	port, err := NewSoftRS485Port(fake, &Mode{BaudRate: 115200}, &SoftRS485Config{InvertRTS: true, DiscardEcho: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := port.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"rts-on", "rts-off", "write", "drain", "rts-on"}
+	if len(fake.calls) != len(expected) {
+		t.Fatalf("unexpected calls %v", fake.calls)
+	}
+	for i := range expected {
+		if fake.calls[i] != expected[i] {
+			t.Fatalf("unexpected calls %v", fake.calls)
+		}
+	}
+
+	fake.rx.WriteString("reply")
+	buf := make([]byte, 64)
+	n, err := port.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "reply" {
+		t.Fatalf("local echo not discarded, got %q", buf[:n])
+	}
+}
+
+func TestSoftRS485PortDefaults(t *testing.T) {
+	// A nil mode and config stand for the defaults
+	fake := &loopbackPort{}
+	port, err := NewSoftRS485Port(fake, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := port.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"rts-off", "rts-on", "write", "drain", "rts-off"}
+	if len(fake.calls) != len(expected) {
+		t.Fatalf("unexpected calls %v", fake.calls)
+	}
+	for i := range expected {
+		if fake.calls[i] != expected[i] {
+			t.Fatalf("unexpected calls %v", fake.calls)
+		}
+	}
+}
+
+func TestCharacterTime(t *testing.T) {
+	tests := []struct {
+		mode     *Mode
+		expected time.Duration
+	}{
+		{nil, 10 * time.Second / 9600},
+		{&Mode{}, 10 * time.Second / 9600},
+		{&Mode{BaudRate: 19200, DataBits: 8, Parity: EvenParity, StopBits: OneStopBit}, 11 * time.Second / 19200},
+		{&Mode{BaudRate: 1000, DataBits: 5, StopBits: OnePointFiveStopBits}, 7500 * time.Microsecond},
+		{&Mode{BaudRate: 1000, DataBits: 7, Parity: OddParity, StopBits: TwoStopBits}, 11 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if charTime := characterTime(test.mode); charTime != test.expected {
+			t.Errorf("characterTime(%+v) = %v, expected %v", test.mode, charTime, test.expected)
+		}
+	}
+}
@@ -6,7 +6,10 @@
 
 package serial
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 //go:generate go run golang.org/x/sys/windows/mkwinsyscall -output zsyscall_windows.go syscall_windows.go
 
@@ -56,7 +59,137 @@ type Port interface {
 	Break(time.Duration) error
 }
 
-// NoTimeout should be used as a parameter to SetReadTimeout to disable timeout.
+// ContextReadWriter is implemented by ports that allow to cancel a blocking
+// Read or Write through a context.Context. Use a type assertion on a Port to
+// check if it's supported.
+type ContextReadWriter interface {
+	// ReadContext works like Read but it returns ctx.Err() if the context
+	// is done before any data is received. The port can still be used
+	// after a canceled ReadContext.
+	ReadContext(ctx context.Context, p []byte) (n int, err error)
+
+	// WriteContext works like Write but it returns ctx.Err() if the context
+	// is done before all the data is sent. The returned n is the number of
+	// bytes written before the cancellation.
+	WriteContext(ctx context.Context, p []byte) (n int, err error)
+}
+
+// DeadlineSetter is implemented by ports that support I/O deadlines with the
+// same semantics of net.Conn: a deadline is an absolute time after which
+// pending and future I/O operations fail with a DeadlineExceeded error, a zero
+// value disables the deadline. Use a type assertion on a Port to check if
+// it's supported.
+//
+// Deadlines are independent from the timeout set with SetReadTimeout: when the
+// read timeout expires Read returns 0 bytes and no error.
+type DeadlineSetter interface {
+	// SetDeadline sets both the read and write deadlines
+	SetDeadline(t time.Time) error
+
+	// SetReadDeadline sets the deadline for Read operations
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline sets the deadline for Write operations, even if a
+	// write times out it may return n > 0, indicating that some of the data
+	// was successfully written.
+	SetWriteDeadline(t time.Time) error
+}
+
+// PortNamer is implemented by ports that can report their name, like the
+// ports returned by Open. The wrappers of a Port should implement it too, it's
+// used for example by NewConn for the address of the connection.
+type PortNamer interface {
+	// PortName returns the name of the port
+	PortName() string
+}
+
+// WriteTimeoutSetter is implemented by ports that support a timeout for the
+// Write operation. Use a type assertion on a Port to check if it's supported.
+type WriteTimeoutSetter interface {
+	// SetWriteTimeout sets the timeout for the Write operation or use
+	// serial.NoTimeout to disable write timeout. If the timeout expires
+	// before all the data is sent, Write returns a DeadlineExceeded error
+	// together with the number of bytes written.
+	SetWriteTimeout(t time.Duration) error
+}
+
+// QueueInspector is implemented by ports that can report the number of bytes
+// waiting in the buffers of the driver. Use a type assertion on a Port to check
+// if it's supported.
+type QueueInspector interface {
+	// InputWaiting returns the number of bytes received and not yet read. If
+	// Mode.ReportByteStatus is set the escape sequences used to report the
+	// errors are counted too.
+	InputWaiting() (int, error)
+
+	// OutputWaiting returns the number of bytes written and not yet sent.
+	// The bytes already moved to the hardware (for example to the FIFO of
+	// the UART or to an USB adapter) are not counted.
+	OutputWaiting() (int, error)
+}
+
+// ModemStatusWatcher is implemented by ports that can wait for changes of the
+// modem status lines (currently only on Linux, where the driver of the port
+// must support the TIOCMIWAIT ioctl). Use a type assertion on a Port to check
+// if it's supported, the functions return a FunctionNotImplemented error if
+// the driver doesn't support it.
+//
+// If the driver also supports the TIOCGICOUNT ioctl, the changes are detected
+// through its transition counters, so even the short pulses that end before
+// the waiter wakes up are reported. Close wakes up all the waiters
+// immediately.
+type ModemStatusWatcher interface {
+	// WaitForModemStatusChange blocks until one of the lines selected by
+	// mask changes, then returns the new status of the lines. It returns
+	// ctx.Err() if the context is done before, or a PortClosed error if
+	// the port is closed.
+	WaitForModemStatusChange(ctx context.Context, mask ModemStatusMask) (*ModemStatusBits, error)
+
+	// ModemEvents returns a channel that receives a ModemEvent for each
+	// change of the modem status lines. The channel is closed when the
+	// context is done, when the port is closed or if an error occurs.
+	ModemEvents(ctx context.Context) (<-chan ModemEvent, error)
+}
+
+// LineStatisticsGetter is implemented by ports that can report the counters
+// of the events on the serial line (currently only on Linux, where the driver
+// of the port must support the TIOCGICOUNT ioctl). Use a type assertion on a
+// Port to check if it's supported.
+type LineStatisticsGetter interface {
+	// GetLineStatistics returns the counters of the serial line.
+	GetLineStatistics() (*LineStatistics, error)
+}
+
+// ByteStatusReader is implemented by ports that can report the receive status
+// of each byte (currently only on unix, through the PARMRK termios setting).
+// Use a type assertion on a Port to check if it's supported.
+//
+// The status is reported only if Mode.ReportByteStatus is set: in this mode the
+// bytes received with a parity or framing error are delivered by Read and
+// ReadWithStatus instead of being silently discarded or replaced, and a break
+// condition is delivered as a 0 byte with the ByteBreak status.
+type ByteStatusReader interface {
+	// ReadWithStatus works like Read but it also stores the receive status
+	// of each byte read into status, that must be at least as long as p.
+	ReadWithStatus(p []byte, status []ByteStatus) (n int, err error)
+}
+
+// RS485Configurer is implemented by ports that can drive an RS485 transceiver
+// through the UART driver (currently only on Linux, using the kernel RS485
+// support). Use a type assertion on a Port to check if it's supported.
+type RS485Configurer interface {
+	// SetRS485Config sets the RS485 configuration of the port. A
+	// FunctionNotImplemented error is returned if the driver of the port
+	// doesn't support RS485, an InvalidSerialPort error if config is nil.
+	SetRS485Config(config *RS485Config) error
+
+	// GetRS485Config reads back the RS485 configuration of the port as
+	// actually applied by the driver.
+	GetRS485Config() (*RS485Config, error)
+}
+
+// NoTimeout should be used as a parameter to SetReadTimeout (or SetWriteTimeout)
+// to disable timeout.
 var NoTimeout time.Duration = -1
 
 // ModemStatusBits contains all the modem input status bits for a serial port (CTS, DSR, etc...).
@@ -68,23 +201,99 @@ type ModemStatusBits struct {
 	DCD bool // DataCarrierDetect status
 }
 
+// ModemStatusMask is a set of modem input status lines.
+type ModemStatusMask int
+
+const (
+	// ModemStatusCTS selects the ClearToSend line
+	ModemStatusCTS ModemStatusMask = 1 << iota
+	// ModemStatusDSR selects the DataSetReady line
+	ModemStatusDSR
+	// ModemStatusRI selects the RingIndicator line
+	ModemStatusRI
+	// ModemStatusDCD selects the DataCarrierDetect line
+	ModemStatusDCD
+)
+
+// ModemEvent describes a change of the modem status lines, it is reported by
+// the ModemStatusWatcher.ModemEvents channel.
+type ModemEvent struct {
+	Time    time.Time       // Time when the change has been detected
+	Changed ModemStatusMask // Lines that changed since the previous event (after a short pulse the status may be unchanged)
+	Status  ModemStatusBits // Status of the lines after the change
+}
+
+// LineStatistics contains the counters of the events on a serial line, as
+// kept by the driver of the port. The counters start when the driver sets up
+// the device (not when the port is opened) and may wrap around.
+type LineStatistics struct {
+	RX             uint32 // Bytes received
+	TX             uint32 // Bytes transmitted
+	FrameErrors    uint32 // Characters received with a framing error
+	ParityErrors   uint32 // Characters received with a parity error
+	Overruns       uint32 // Characters lost because the hardware receive FIFO was full
+	BufferOverruns uint32 // Characters lost because the input buffer of the driver was full
+	Breaks         uint32 // Break conditions received
+	CTSChanges     uint32 // Transitions of the ClearToSend line
+	DSRChanges     uint32 // Transitions of the DataSetReady line
+	RIChanges      uint32 // Transitions of the RingIndicator line
+	DCDChanges     uint32 // Transitions of the DataCarrierDetect line
+}
+
+// ByteStatus is the receive status of a byte, see ByteStatusReader.
+type ByteStatus byte
+
+const (
+	// ByteOK the byte has been received without errors
+	ByteOK ByteStatus = iota
+	// ByteParityError the byte has been received with a parity error. The
+	// driver doesn't tell parity and framing errors apart: when the parity is
+	// enabled a framing error is reported as a parity error
+	ByteParityError
+	// ByteFramingError the byte has been received with a framing error
+	ByteFramingError
+	// ByteBreak a break condition has been received
+	ByteBreak
+)
+
 // ModemOutputBits contains all the modem output bits for a serial port.
 // This is used in the Mode.InitialStatusBits struct to specify the initial status of the bits.
 // Note: Linux and MacOSX (and basically all unix-based systems) can not set the status bits
 // before opening the port, even if the initial state of the bit is set to false they will go
-// anyway to true for a few milliseconds, resulting in a small pulse.
+// anyway to true for a few milliseconds, resulting in a small pulse. To leave the
+// lines untouched use the WithKeepModemLines and WithHangupOnClose options.
 type ModemOutputBits struct {
 	RTS bool // ReadyToSend status
 	DTR bool // DataTerminalReady status
 }
 
+// RS485Config contains the RS485 configuration of a serial port, it is used
+// with the RS485Configurer interface.
+// Note: the delays are applied by the driver with a resolution of 1
+// millisecond, they are rounded up to the next millisecond.
+type RS485Config struct {
+	Enabled            bool          // Enable RS485 mode
+	RTSOnSend          bool          // Logical level of RTS while sending
+	RTSAfterSend       bool          // Logical level of RTS after sending
+	DelayRTSBeforeSend time.Duration // Delay between setting RTS and the start of the transmission
+	DelayRTSAfterSend  time.Duration // Delay between the end of the transmission and resetting RTS
+	RxDuringTx         bool          // Keep the receiver enabled while sending
+	TerminateBus       bool          // Enable the bus termination (if supported by the hardware)
+}
+
 // Open opens the serial port using the specified modes
 func Open(portName string, mode *Mode) (Port, error) {
-	port, err := nativeOpen(portName, mode)
+	return OpenWithOptions(portName, WithMode(mode))
+}
+
+// OpenWithOptions opens the serial port using the specified options (see
+// the Option type)
+func OpenWithOptions(portName string, opts ...Option) (Port, error) {
+	port, err := nativeOpen(portName, newOpenOptions(opts))
 	if err != nil {
 		// Return a nil interface, for which var==nil is true (instead of
 		// a nil pointer to a struct that satisfies the interface).
-		return nil, err
+		return nil, withOp(err, "open", portName)
 	}
 	return port, err
 }
@@ -101,6 +310,10 @@ type Mode struct {
 	Parity            Parity           // Parity (see Parity type for more info)
 	StopBits          StopBits         // Stop bits (see StopBits type for more info)
 	InitialStatusBits *ModemOutputBits // Initial output modem bits status (if nil defaults to DTR=true and RTS=true)
+	FlowControl       FlowControl      // Flow control (see FlowControl type for more info)
+	XonChar           byte             // Character used to resume transmission with XONXOFFFlowControl (if 0 defaults to DC1)
+	XoffChar          byte             // Character used to pause transmission with XONXOFFFlowControl (if 0 defaults to DC3)
+	ReportByteStatus  bool             // Report the receive errors of each byte (see ByteStatusReader, not available on Windows)
 }
 
 // Parity describes a serial port parity setting
@@ -131,8 +344,35 @@ const (
 	TwoStopBits
 )
 
-// PortError is a platform independent error type for serial ports
+// FlowControl describes a serial port flow control setting
+type FlowControl int
+
+const (
+	// NoFlowControl disable flow control (default)
+	NoFlowControl FlowControl = iota
+	// RTSCTSFlowControl enable hardware flow control using the RTS and CTS lines
+	RTSCTSFlowControl
+	// XONXOFFFlowControl enable software flow control using the XON and XOFF
+	// characters (see Mode.XonChar and Mode.XoffChar)
+	XONXOFFFlowControl
+	// DTRDSRFlowControl enable hardware flow control using the DTR and DSR lines
+	// (not available on Linux and OpenBSD)
+	DTRDSRFlowControl
+)
+
+const (
+	defaultXonChar  = 0x11 // DC1
+	defaultXoffChar = 0x13 // DC3
+)
+
+// PortError is a platform independent error type for serial ports. It can be
+// compared with errors.Is against the sentinel errors (ErrPortBusy,
+// ErrPortClosed, ...), that match any PortError with the same code, and it
+// unwraps to the underlying error, if any.
 type PortError struct {
+	Op   string // The operation that failed (open, read, write...), if known
+	Port string // The name of the port, if known
+
 	code     PortErrorCode
 	causedBy error
 }
@@ -165,6 +405,34 @@ const (
 	PortClosed
 	// FunctionNotImplemented the requested function is not implemented
 	FunctionNotImplemented
+	// InvalidFlowControl the selected flow control is not valid or not supported
+	InvalidFlowControl
+	// DeadlineExceeded the operation has not been completed before its deadline or timeout
+	DeadlineExceeded
+	// DeviceRemoved the device has been disconnected, the port must be closed
+	DeviceRemoved
+	// MultiplePortsFound more than one port matches the requested one
+	MultiplePortsFound
+)
+
+// Sentinel errors, one for each PortErrorCode, to be used with errors.Is.
+var (
+	ErrPortBusy               = &PortError{code: PortBusy}
+	ErrPortNotFound           = &PortError{code: PortNotFound}
+	ErrInvalidSerialPort      = &PortError{code: InvalidSerialPort}
+	ErrPermissionDenied       = &PortError{code: PermissionDenied}
+	ErrInvalidSpeed           = &PortError{code: InvalidSpeed}
+	ErrInvalidDataBits        = &PortError{code: InvalidDataBits}
+	ErrInvalidParity          = &PortError{code: InvalidParity}
+	ErrInvalidStopBits        = &PortError{code: InvalidStopBits}
+	ErrInvalidTimeoutValue    = &PortError{code: InvalidTimeoutValue}
+	ErrEnumeratingPorts       = &PortError{code: ErrorEnumeratingPorts}
+	ErrPortClosed             = &PortError{code: PortClosed}
+	ErrFunctionNotImplemented = &PortError{code: FunctionNotImplemented}
+	ErrInvalidFlowControl     = &PortError{code: InvalidFlowControl}
+	ErrDeadlineExceeded       = &PortError{code: DeadlineExceeded}
+	ErrDeviceRemoved          = &PortError{code: DeviceRemoved}
+	ErrMultiplePortsFound     = &PortError{code: MultiplePortsFound}
 )
 
 // EncodedErrorString returns a string explaining the error code
@@ -194,6 +462,14 @@ func (e PortError) EncodedErrorString() string {
 		return "Port has been closed"
 	case FunctionNotImplemented:
 		return "Function not implemented"
+	case InvalidFlowControl:
+		return "Port flow control invalid or not supported"
+	case DeadlineExceeded:
+		return "I/O timeout"
+	case DeviceRemoved:
+		return "Device removed"
+	case MultiplePortsFound:
+		return "Multiple serial ports found"
 	default:
 		return "Other error"
 	}
@@ -201,13 +477,56 @@ func (e PortError) EncodedErrorString() string {
 
 // Error returns the complete error code with details on the cause of the error
 func (e PortError) Error() string {
+	msg := e.EncodedErrorString()
 	if e.causedBy != nil {
-		return e.EncodedErrorString() + ": " + e.causedBy.Error()
+		msg += ": " + e.causedBy.Error()
+	}
+	if e.Op != "" {
+		if e.Port != "" {
+			return e.Op + " " + e.Port + ": " + msg
+		}
+		return e.Op + ": " + msg
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error, if any
+func (e PortError) Unwrap() error {
+	return e.causedBy
+}
+
+// Is returns true if target is a PortError with the same code, this allows
+// to compare errors with the sentinel errors using errors.Is.
+func (e PortError) Is(target error) bool {
+	switch t := target.(type) {
+	case *PortError:
+		return t != nil && t.code == e.code
+	case PortError:
+		return t.code == e.code
 	}
-	return e.EncodedErrorString()
+	return false
 }
 
 // Code returns an identifier for the kind of error occurred
 func (e PortError) Code() PortErrorCode {
 	return e.code
 }
+
+// Timeout returns true if the error is caused by an expired deadline
+// (see DeadlineSetter) or write timeout (see WriteTimeoutSetter).
+func (e PortError) Timeout() bool {
+	return e.code == DeadlineExceeded
+}
+
+// withOp returns a copy of err with the operation and the port name set, if
+// it's a PortError that doesn't have them yet. The PortError is copied since
+// it may be shared, other errors are returned unchanged.
+func withOp(err error, op, port string) error {
+	if portErr, ok := err.(*PortError); ok && portErr.Op == "" {
+		res := *portErr
+		res.Op = op
+		res.Port = port
+		return &res
+	}
+	return err
+}
@@ -11,5 +11,11 @@ package serial
 import "golang.org/x/sys/unix"
 
 func (port *unixPort) Drain() error {
-	return unix.IoctlSetInt(port.handle, unix.TIOCDRAIN, 0)
+	return port.opError("drain", unix.IoctlSetInt(port.handle, unix.TIOCDRAIN, 0))
 }
+
+// modemWatcher is not available on this system, the modem status lines can't
+// be watched.
+type modemWatcher struct{}
+
+func (port *unixPort) stopModemWatcher() {}
@@ -0,0 +1,101 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build linux || darwin || freebsd || openbsd
+
+package serial
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// With PARMRK set the driver delivers a byte received with a parity or
+// framing error as the sequence 0xFF 0x00 <byte>, a break as 0xFF 0x00 0x00
+// and a valid 0xFF byte as 0xFF 0xFF.
+const parmrkEscape = 0xFF
+
+func setTermSettingsByteStatus(mode *Mode, settings *unix.Termios) {
+	if mode.ReportByteStatus {
+		// INPCK is required to mark framing errors even without parity
+		settings.Iflag |= unix.INPCK
+		settings.Iflag |= unix.PARMRK
+		settings.Iflag &^= unix.IGNPAR
+	} else {
+		settings.Iflag &^= unix.PARMRK
+	}
+}
+
+func (port *unixPort) setErrorMark(mode *Mode) {
+	errorMark := ByteOK
+	if mode.ReportByteStatus {
+		if mode.Parity == NoParity {
+			errorMark = ByteFramingError
+		} else {
+			errorMark = ByteParityError
+		}
+	}
+	atomic.StoreUint32(&port.errorMark, uint32(errorMark))
+}
+
+// decodeErrorMarks decodes in place the PARMRK escape sequences in the first n
+// bytes of p, storing the status of each decoded byte in status (if not nil).
+// An incomplete sequence at the end of p is kept for the next read. It returns
+// the number of decoded bytes.
+func (port *unixPort) decodeErrorMarks(p []byte, n int, status []ByteStatus, errorMark ByteStatus) int {
+	raw := p[:n]
+	if len(port.markPending) > 0 {
+		raw = append(port.markPending, raw...)
+		port.markPending = nil
+	}
+
+	// Each decoded byte takes at least one byte from p, so the decoded
+	// data never overruns the raw data still to be decoded
+	decoded := 0
+	emit := func(b byte, s ByteStatus) {
+		p[decoded] = b
+		if status != nil {
+			status[decoded] = s
+		}
+		decoded++
+	}
+	i := 0
+	for i < len(raw) {
+		if raw[i] != parmrkEscape {
+			emit(raw[i], ByteOK)
+			i++
+			continue
+		}
+		if i+1 >= len(raw) {
+			break
+		}
+		if raw[i+1] == parmrkEscape {
+			emit(parmrkEscape, ByteOK)
+			i += 2
+			continue
+		}
+		if raw[i+1] != 0x00 {
+			// Not an escape sequence
+			emit(parmrkEscape, ByteOK)
+			i++
+			continue
+		}
+		if i+2 >= len(raw) {
+			break
+		}
+		if b := raw[i+2]; b == 0x00 {
+			emit(b, ByteBreak)
+		} else {
+			emit(b, errorMark)
+		}
+		i += 3
+	}
+	if i < len(raw) {
+		port.markPending = append([]byte(nil), raw[i:]...)
+	}
+	return decoded
+}
@@ -13,6 +13,7 @@ import (
 )
 
 const devFolder = "/dev"
+const lockFolder = "/var/spool/uucp"
 
 var osPortFilter = regexp.MustCompile(`^(cu|tty)\..*`)
 
@@ -21,6 +22,7 @@ const ioctlTcsetattr = unix.TIOCSETA
 const ioctlTcflsh = unix.TIOCFLUSH
 const ioctlTioccbrk = unix.TIOCCBRK
 const ioctlTiocsbrk = unix.TIOCSBRK
+const ioctlFionread = 0x4004667f // FIONREAD
 
 func setTermSettingsBaudrate(speed int, settings *unix.Termios) (error, bool) {
 	baudrate, ok := baudrateMap[speed]
@@ -38,9 +40,9 @@ func (port *unixPort) setSpecialBaudrate(speed uint32) error {
 }
 
 func (port *unixPort) ResetInputBuffer() error {
-	return unix.IoctlSetPointerInt(port.handle, ioctlTcflsh, unix.TCIFLUSH)
+	return port.opError("reset input buffer", unix.IoctlSetPointerInt(port.handle, ioctlTcflsh, unix.TCIFLUSH))
 }
 
 func (port *unixPort) ResetOutputBuffer() error {
-	return unix.IoctlSetPointerInt(port.handle, ioctlTcflsh, unix.TCOFLUSH)
+	return port.opError("reset output buffer", unix.IoctlSetPointerInt(port.handle, ioctlTcflsh, unix.TCOFLUSH))
 }
@@ -48,6 +48,11 @@ const tcCRTS_IFLOW uint32 = 0x00020000
 
 const tcCRTSCTS uint32 = (tcCCTS_OFLOW | tcCRTS_IFLOW)
 
+const tcCDTR_IFLOW uint32 = 0x00040000
+const tcCDSR_OFLOW uint32 = 0x00080000
+
+const tcCDTRDSR uint32 = (tcCDTR_IFLOW | tcCDSR_OFLOW)
+
 func toTermiosSpeedType(speed uint32) uint32 {
 	return speed
 }
@@ -50,6 +50,11 @@ const tcCRTS_IFLOW uint64 = 0x00020000
 
 const tcCRTSCTS uint64 = (tcCCTS_OFLOW | tcCRTS_IFLOW)
 
+const tcCDTR_IFLOW uint64 = 0x00040000
+const tcCDSR_OFLOW uint64 = 0x00080000
+
+const tcCDTRDSR uint64 = (tcCDTR_IFLOW | tcCDSR_OFLOW)
+
 func toTermiosSpeedType(speed uint64) uint64 {
 	return speed
 }
@@ -0,0 +1,163 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenDiagnosis contains the information collected by DiagnoseOpenError about
+// a port that could not be opened. Use String to get a human readable report.
+type OpenDiagnosis struct {
+	Err        error        // The error returned when opening the port
+	Holders    []PortHolder // The processes that have the port open
+	Incomplete bool         // True if some processes could not be inspected (missing privileges)
+	Group      string       // The group owning the device
+	InGroup    bool         // True if the current user is a member of Group
+	Accessible bool         // True if the current user can read and write the device
+	Console    bool         // True if the port is used as kernel console
+	Getty      bool         // True if a getty is attached to the port
+}
+
+// PortHolder is a process that has a port open.
+type PortHolder struct {
+	PID     int
+	Cmdline string
+}
+
+// DiagnoseOpenError collects the information useful to understand why the
+// port could not be opened, typically after a PortBusy or PermissionDenied
+// error returned by Open: the processes that have the port open, the group
+// owning the device and if the current user is a member of it, and if the
+// port is used as kernel console or by a getty. Inspecting the processes of
+// other users requires root privileges.
+func DiagnoseOpenError(portName string, openErr error) (*OpenDiagnosis, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(portName, &st); err != nil {
+		return nil, &PortError{code: PortNotFound, causedBy: err}
+	}
+	d := &OpenDiagnosis{
+		Err:        openErr,
+		Accessible: unix.Access(portName, unix.R_OK|unix.W_OK) == nil,
+	}
+
+	if group, err := user.LookupGroupId(strconv.Itoa(int(st.Gid))); err == nil {
+		d.Group = group.Name
+	} else {
+		d.Group = strconv.Itoa(int(st.Gid))
+	}
+	if gid := int(st.Gid); os.Getegid() == gid {
+		d.InGroup = true
+	} else if groups, err := os.Getgroups(); err == nil {
+		for _, g := range groups {
+			if g == gid {
+				d.InGroup = true
+				break
+			}
+		}
+	}
+
+	d.findHolders(uint64(st.Rdev))
+	for _, holder := range d.Holders {
+		cmd := strings.Fields(holder.Cmdline)
+		if len(cmd) > 0 && strings.Contains(filepath.Base(cmd[0]), "getty") {
+			d.Getty = true
+		}
+	}
+
+	// The active consoles are listed as "tty0 ttyS0 ..."
+	if resolved, err := filepath.EvalSymlinks(portName); err == nil {
+		if active, err := os.ReadFile("/sys/class/tty/console/active"); err == nil {
+			for _, console := range strings.Fields(string(active)) {
+				if console == filepath.Base(resolved) {
+					d.Console = true
+				}
+			}
+		}
+	}
+	return d, nil
+}
+
+// findHolders scans the file descriptors of all the processes looking for
+// the device rdev.
+func (d *OpenDiagnosis) findHolders(rdev uint64) {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		d.Incomplete = true
+		return
+	}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			if os.IsPermission(err) {
+				d.Incomplete = true
+			}
+			continue
+		}
+		for _, fd := range fds {
+			var st unix.Stat_t
+			if err := unix.Stat(filepath.Join(fdDir, fd.Name()), &st); err != nil {
+				continue
+			}
+			if st.Mode&unix.S_IFMT == unix.S_IFCHR && uint64(st.Rdev) == rdev {
+				cmdline, _ := os.ReadFile(filepath.Join("/proc", proc.Name(), "cmdline"))
+				d.Holders = append(d.Holders, PortHolder{
+					PID:     pid,
+					Cmdline: strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " ")),
+				})
+				break
+			}
+		}
+	}
+}
+
+// String returns a human readable report of the diagnosis.
+func (d *OpenDiagnosis) String() string {
+	var report []string
+	if d.Err != nil {
+		report = append(report, d.Err.Error())
+	}
+	for _, holder := range d.Holders {
+		if holder.PID == os.Getpid() {
+			report = append(report, fmt.Sprintf("the port is open by this process (%d)", holder.PID))
+		} else {
+			report = append(report, fmt.Sprintf("the port is open by process %d (%s)", holder.PID, holder.Cmdline))
+		}
+	}
+	if d.Getty {
+		report = append(report, "a getty is attached to the port, disable the login console on it to use the port")
+	}
+	if d.Console {
+		report = append(report, "the port is used as kernel console (see the console= kernel parameter)")
+	}
+	if !d.Accessible {
+		if d.InGroup {
+			report = append(report, fmt.Sprintf("the current user is a member of group %s but can't access the device", d.Group))
+		} else {
+			report = append(report, fmt.Sprintf("the device belongs to group %s and the current user is not a member of it", d.Group))
+		}
+	}
+	if d.Incomplete {
+		report = append(report, "some processes could not be inspected, run as root for a complete report")
+	}
+	if len(report) == 0 || (len(report) == 1 && d.Err != nil) {
+		report = append(report, "no known cause found")
+	}
+	return strings.Join(report, "\n")
+}
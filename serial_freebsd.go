@@ -13,6 +13,7 @@ import (
 )
 
 const devFolder = "/dev"
+const lockFolder = "/var/spool/lock"
 
 var osPortFilter = regexp.MustCompile("^(cu|tty)\\..*")
 
@@ -56,13 +57,19 @@ const tcIUCLC uint32 = 0
 const tcCCTS_OFLOW uint32 = 0x00010000
 const tcCRTS_IFLOW uint32 = 0x00020000
 
-const tcCRTSCTS uint32 = tcCCTS_OFLOW
+const tcCRTSCTS uint32 = (tcCCTS_OFLOW | tcCRTS_IFLOW)
+
+const tcCDTR_IFLOW uint32 = 0x00040000
+const tcCDSR_OFLOW uint32 = 0x00080000
+
+const tcCDTRDSR uint32 = (tcCDTR_IFLOW | tcCDSR_OFLOW)
 
 const ioctlTcgetattr = unix.TIOCGETA
 const ioctlTcsetattr = unix.TIOCSETA
 const ioctlTcflsh = unix.TIOCFLUSH
 const ioctlTioccbrk = unix.TIOCCBRK
 const ioctlTiocsbrk = unix.TIOCSBRK
+const ioctlFionread = 0x4004667f // FIONREAD
 
 func toTermiosSpeedType(speed uint32) uint32 {
 	return speed
@@ -13,6 +13,7 @@ import (
 )
 
 const devFolder = "/dev"
+const lockFolder = "/var/lock"
 
 var osPortFilter = regexp.MustCompile("(ttyS|ttyHS|ttyUSB|ttyACM|ttyAMA|rfcomm|ttyO|ttymxc)[0-9]{1,3}")
 
@@ -64,12 +65,14 @@ const tcCMSPAR = unix.CMSPAR
 const tcIUCLC = unix.IUCLC
 
 const tcCRTSCTS uint32 = unix.CRTSCTS
+const tcCDTRDSR uint32 = 0 // DTR/DSR flow control is not supported
 
 const ioctlTcgetattr = unix.TCGETS
 const ioctlTcsetattr = unix.TCSETS
 const ioctlTcflsh = unix.TCFLSH
 const ioctlTioccbrk = unix.TIOCCBRK
 const ioctlTiocsbrk = unix.TIOCSBRK
+const ioctlFionread = unix.TIOCINQ
 
 func toTermiosSpeedType(speed uint32) uint32 {
 	return speed
@@ -95,5 +98,5 @@ func (port *unixPort) Drain() error {
 	// It's not super well documented, but this is the same as calling tcdrain:
 	// - https://git.musl-libc.org/cgit/musl/tree/src/termios/tcdrain.c
 	// - https://elixir.bootlin.com/linux/v6.2.8/source/drivers/tty/tty_io.c#L2673
-	return unix.IoctlSetInt(port.handle, unix.TCSBRK, 1)
+	return port.opError("drain", unix.IoctlSetInt(port.handle, unix.TCSBRK, 1))
 }
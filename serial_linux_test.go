@@ -11,29 +11,38 @@ package serial
 
 import (
 	"context"
-	"os/exec"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
-func startSocatAndWaitForPort(t *testing.T, ctx context.Context) *exec.Cmd {
-	t.Helper()
-	cmd := exec.CommandContext(ctx, "socat", "-D", "STDIO", "pty,link=/tmp/faketty")
-	r, err := cmd.StderrPipe()
+// openPty opens a pseudo-terminal, it returns the master side and the name of
+// the slave device.
+func openPty(tb testing.TB) (*os.File, string) {
+	tb.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
 	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+		tb.Skipf("pseudo-terminals not available: %v", err)
 	}
-	if err := cmd.Start(); err != nil {
-		t.Fatalf("unexpected error: %v", err)
+	tb.Cleanup(func() { master.Close() })
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		tb.Fatalf("unexpected error: %v", err)
 	}
-	// Let our fake serial port node appear.
-	// socat will write to stderr before starting transfer phase;
-	// we don't really care what, just that it did, because then it's ready.
-	buf := make([]byte, 1024)
-	if _, err = r.Read(buf); err != nil {
-		t.Fatalf("unexpected error: %v", err)
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		tb.Fatalf("unexpected error: %v", err)
 	}
-	return cmd
+	return master, fmt.Sprintf("/dev/pts/%d", n)
 }
 
 func TestSerialReadAndCloseConcurrency(t *testing.T) {
@@ -41,12 +50,13 @@ func TestSerialReadAndCloseConcurrency(t *testing.T) {
 	// Run this test with race detector to actually test that
 	// the correct multitasking behaviour is happening.
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	cmd := startSocatAndWaitForPort(t, ctx)
-	go cmd.Wait()
+	master, name, err := OpenPseudoTerminal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer master.Close()
 
-	port, err := Open("/tmp/faketty", &Mode{})
+	port, err := Open(name, &Mode{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -58,12 +68,13 @@ func TestSerialReadAndCloseConcurrency(t *testing.T) {
 }
 
 func TestDoubleCloseIsNoop(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	cmd := startSocatAndWaitForPort(t, ctx)
-	go cmd.Wait()
+	master, name, err := OpenPseudoTerminal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer master.Close()
 
-	port, err := Open("/tmp/faketty", &Mode{})
+	port, err := Open(name, &Mode{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -74,3 +85,1086 @@ func TestDoubleCloseIsNoop(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestSetTermSettingsFlowControl(t *testing.T) {
+	settings := &unix.Termios{}
+	if err := setTermSettingsFlowControl(&Mode{FlowControl: RTSCTSFlowControl}, settings); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if settings.Cflag&unix.CRTSCTS == 0 {
+		t.Fatalf("CRTSCTS not set")
+	}
+
+	if err := setTermSettingsFlowControl(&Mode{FlowControl: XONXOFFFlowControl, XoffChar: 0x1A}, settings); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if settings.Cflag&unix.CRTSCTS != 0 {
+		t.Fatalf("CRTSCTS not cleared")
+	}
+	if settings.Iflag&(unix.IXON|unix.IXOFF) != unix.IXON|unix.IXOFF {
+		t.Fatalf("IXON/IXOFF not set")
+	}
+	if settings.Cc[unix.VSTART] != 0x11 || settings.Cc[unix.VSTOP] != 0x1A {
+		t.Fatalf("wrong XON/XOFF chars: %x %x", settings.Cc[unix.VSTART], settings.Cc[unix.VSTOP])
+	}
+
+	err := setTermSettingsFlowControl(&Mode{FlowControl: DTRDSRFlowControl}, settings)
+	if portErr, ok := err.(*PortError); !ok || portErr.Code() != InvalidFlowControl {
+		t.Fatalf("expected InvalidFlowControl error, got %v", err)
+	}
+}
+
+func TestToRS485Delay(t *testing.T) {
+	tests := map[time.Duration]uint32{
+		-time.Second:                 0,
+		0:                            0,
+		time.Microsecond:             1,
+		time.Millisecond:             1,
+		1500 * time.Microsecond:      2,
+		time.Second:                  1000,
+		time.Duration(math.MaxInt64): math.MaxUint32,
+	}
+	for d, expected := range tests {
+		if ms := toRS485Delay(d); ms != expected {
+			t.Errorf("toRS485Delay(%v) = %d, expected %d", d, ms, expected)
+		}
+	}
+}
+
+func TestDecodeErrorMarks(t *testing.T) {
+	port := &unixPort{}
+	decode := func(raw []byte) ([]byte, []ByteStatus) {
+		p := append([]byte(nil), raw...)
+		status := make([]ByteStatus, len(p))
+		n := port.decodeErrorMarks(p, len(p), status, ByteParityError)
+		return p[:n], status[:n]
+	}
+
+	data, status := decode([]byte{'a', 0xFF, 0xFF, 0xFF, 0x00, 'b', 0xFF, 0x00, 0x00, 'c'})
+	if string(data) != "a\xFFb\x00c" {
+		t.Fatalf("unexpected data %q", data)
+	}
+	expected := []ByteStatus{ByteOK, ByteOK, ByteParityError, ByteBreak, ByteOK}
+	for i := range expected {
+		if status[i] != expected[i] {
+			t.Fatalf("unexpected status %v", status)
+		}
+	}
+
+	// An escape sequence split across reads
+	if data, _ := decode([]byte{'a', 0xFF}); string(data) != "a" {
+		t.Fatalf("unexpected data %q", data)
+	}
+	if data, _ := decode([]byte{0x00}); len(data) != 0 {
+		t.Fatalf("unexpected data %q", data)
+	}
+	if data, status := decode([]byte{'b', 'c'}); string(data) != "bc" || status[0] != ByteParityError || status[1] != ByteOK {
+		t.Fatalf("unexpected data %q %v", data, status)
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	benchmarkRead(b)
+}
+
+func BenchmarkReadRuntimePoller(b *testing.B) {
+	benchmarkRead(b, WithRuntimePoller())
+}
+
+func benchmarkRead(b *testing.B, opts ...Option) {
+	master, name := openPty(b)
+	port, err := OpenWithOptions(name, opts...)
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	defer port.Close()
+
+	data := []byte{0x55}
+	buf := make([]byte, 1)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := master.Write(data); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := port.Read(buf); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestReadHighFileDescriptor(t *testing.T) {
+	master, name := openPty(t)
+	port, err := Open(name, &Mode{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer port.Close()
+
+	// Move the port above FD_SETSIZE (1024), out of the reach of select(2)
+	p := port.(*unixPort)
+	high, err := unix.FcntlInt(uintptr(p.handle), unix.F_DUPFD_CLOEXEC, 1500)
+	if err != nil {
+		t.Skipf("can't allocate a high file descriptor: %v", err)
+	}
+	unix.Close(p.handle)
+	p.handle = high
+
+	if _, err := master.Write([]byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port.SetReadTimeout(time.Second)
+	buf := make([]byte, 10)
+	n, err := port.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("unexpected data %q", buf[:n])
+	}
+}
+
+func TestRuntimePoller(t *testing.T) {
+	master, name := openPty(t)
+	port, err := OpenWithOptions(name, WithMode(&Mode{BaudRate: 115200}), WithRuntimePoller())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := master.Write([]byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := make([]byte, 10)
+	n, err := port.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("unexpected data %q", buf[:n])
+	}
+
+	// The read timeout is still honored
+	port.SetReadTimeout(10 * time.Millisecond)
+	if n, err := port.Read(buf); n != 0 || err != nil {
+		t.Fatalf("expected timeout, got %d %v", n, err)
+	}
+
+	// Close wakes up a pending Read
+	port.SetReadTimeout(NoTimeout)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		port.Close()
+	}()
+	_, err = port.Read(buf)
+	if portErr, ok := err.(*PortError); !ok || portErr.Code() != PortClosed {
+		t.Fatalf("expected PortClosed error, got %v", err)
+	}
+}
+
+func TestSyscallConn(t *testing.T) {
+	t.Run("Select", func(t *testing.T) { testSyscallConn(t) })
+	t.Run("RuntimePoller", func(t *testing.T) { testSyscallConn(t, WithRuntimePoller()) })
+}
+
+func testSyscallConn(t *testing.T, opts ...Option) {
+	master, name := openPty(t)
+	port, err := OpenWithOptions(name, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn, err := port.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		_, ioctlErr = unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	}); err != nil || ioctlErr != nil {
+		t.Fatalf("unexpected error: %v %v", err, ioctlErr)
+	}
+
+	// Read waits until the function succeeds
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		master.Write([]byte("hello"))
+	}()
+	buf := make([]byte, 10)
+	var n int
+	if err := conn.Read(func(fd uintptr) bool {
+		n, ioctlErr = unix.Read(int(fd), buf)
+		return ioctlErr != unix.EAGAIN
+	}); err != nil || ioctlErr != nil {
+		t.Fatalf("unexpected error: %v %v", err, ioctlErr)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("unexpected data %q", buf[:n])
+	}
+
+	// Close wakes up a pending Read
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		port.Close()
+	}()
+	err = conn.Read(func(fd uintptr) bool { return false })
+	if portErr, ok := err.(*PortError); !ok || portErr.Code() != PortClosed {
+		t.Fatalf("expected PortClosed error, got %v", err)
+	}
+	err = conn.Control(func(fd uintptr) { t.Fatal("called on a closed port") })
+	if portErr, ok := err.(*PortError); !ok || portErr.Code() != PortClosed {
+		t.Fatalf("expected PortClosed error, got %v", err)
+	}
+}
+
+func TestNewPortFromFile(t *testing.T) {
+	master, name := openPty(t)
+	slave, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer slave.Close()
+	fd := int(slave.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The current settings are kept
+	port, err := NewPortFromFile(slave, nil, WithKeepTermSettings())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, err := unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if *current != *termios {
+		t.Fatalf("term settings changed: %+v", current)
+	}
+	port.Close()
+
+	// The port is set up as if it was opened
+	port, err = NewPortFromFile(slave, &Mode{BaudRate: 115200})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, err := unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if current.Lflag&unix.ICANON != 0 || current.Cflag&unix.CBAUD != unix.B115200 {
+		t.Fatalf("port not configured: %+v", current)
+	}
+	if _, err := master.Write([]byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := make([]byte, 10)
+	n, err := port.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Fatalf("unexpected data %q", buf[:n])
+	}
+
+	// Closing the port doesn't close the file
+	port.Close()
+	if _, err := slave.Write([]byte("!")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestOpenPseudoTerminal(t *testing.T) {
+	master, name, err := OpenPseudoTerminal(WithMode(&Mode{BaudRate: 115200}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer master.Close()
+
+	// Reading from the master doesn't fail while the slave is not opened
+	buf := make([]byte, 10)
+	master.SetReadTimeout(10 * time.Millisecond)
+	if n, err := master.Read(buf); n != 0 || err != nil {
+		t.Fatalf("expected timeout, got %d %v", n, err)
+	}
+
+	port, err := Open(name, &Mode{BaudRate: 115200})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer port.Close()
+	master.SetReadTimeout(time.Second)
+	port.SetReadTimeout(time.Second)
+	for _, w := range []struct{ from, to Port }{{master, port}, {port, master}} {
+		if _, err := w.from.Write([]byte("hello")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		n, err := w.to.Read(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(buf[:n]) != "hello" {
+			t.Fatalf("unexpected data %q", buf[:n])
+		}
+	}
+}
+
+func TestNullModemPair(t *testing.T) {
+	pair, err := OpenNullModemPair()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer pair.Close()
+
+	var ports [2]Port
+	for i, name := range pair.Names {
+		if ports[i], err = Open(name, &Mode{BaudRate: 115200}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer ports[i].Close()
+		ports[i].SetReadTimeout(time.Second)
+	}
+	buf := make([]byte, 10)
+	for i := range ports {
+		from, to := ports[i], ports[1-i]
+		if _, err := from.Write([]byte("hello")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The data may be forwarded in more chunks
+		var data []byte
+		for len(data) < 5 {
+			n, err := to.Read(buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n == 0 {
+				t.Fatalf("timeout, received %q", data)
+			}
+			data = append(data, buf[:n]...)
+		}
+		if string(data) != "hello" {
+			t.Fatalf("unexpected data %q", data)
+		}
+	}
+}
+
+func TestRestoreOnClose(t *testing.T) {
+	master, name := openPty(t)
+	fd := int(master.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	port, err := OpenWithOptions(name, WithMode(&Mode{BaudRate: 115200}), WithRestoreOnClose())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, err := unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if *current == *termios {
+		t.Fatalf("term settings not changed")
+	}
+	if err := port.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, err := unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if *current != *termios {
+		t.Fatalf("term settings not restored: %+v", current)
+	}
+}
+
+func TestHangupOnClose(t *testing.T) {
+	master, name := openPty(t)
+	fd := int(master.Fd())
+	for _, hangup := range []bool{false, true} {
+		port, err := OpenWithOptions(name, WithHangupOnClose(hangup))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if (termios.Cflag&unix.HUPCL != 0) != hangup {
+			t.Fatalf("HUPCL not set to %v", hangup)
+		}
+		port.Close()
+	}
+}
+
+func TestOpenOptions(t *testing.T) {
+	_, name := openPty(t)
+	slave, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer slave.Close()
+	fd := int(slave.Fd())
+
+	for _, exclusive := range []bool{true, false} {
+		port, err := OpenWithOptions(name, WithExclusiveAccess(exclusive))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if excl, err := unix.IoctlGetInt(fd, unix.TIOCGEXCL); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if (excl != 0) != exclusive {
+			t.Fatalf("exclusive access %v, expected %v", excl, exclusive)
+		}
+		port.Close()
+		if excl, err := unix.IoctlGetInt(fd, unix.TIOCGEXCL); err != nil || excl != 0 {
+			t.Fatalf("exclusive access not released: %v %v", excl, err)
+		}
+	}
+
+	port, err := OpenWithOptions(name, WithReadTimeout(10*time.Millisecond), WithTermSettingsHook(func(settings *unix.Termios) error {
+		settings.Cc[unix.VEOL] = 42
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := port.Read(make([]byte, 10)); n != 0 || err != nil {
+		t.Fatalf("expected timeout, got %d %v", n, err)
+	}
+	if termios, err := unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if termios.Cc[unix.VEOL] != 42 {
+		t.Fatalf("term settings hook not applied: %+v", termios)
+	}
+	port.Close()
+
+	hookErr := fmt.Errorf("hook error")
+	_, err = OpenWithOptions(name, WithTermSettingsHook(func(settings *unix.Termios) error {
+		return hookErr
+	}))
+	if portErr, ok := err.(*PortError); !ok || portErr.Code() != InvalidSerialPort {
+		t.Fatalf("expected InvalidSerialPort error, got %v", err)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	_, name := openPty(t)
+	dir := t.TempDir()
+	lockFile := dir + "/LCK.." + name[len("/dev/pts/"):]
+	opts := []Option{WithLockFile(dir), WithExclusiveAccess(false)}
+
+	port, err := OpenWithOptions(name, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := os.ReadFile(lockFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if string(data) != fmt.Sprintf("%10d\n", os.Getpid()) {
+		t.Fatalf("unexpected lock file content %q", data)
+	}
+	if info, err := os.Stat(lockFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if info.Mode().Perm() != 0644 {
+		t.Fatalf("unexpected lock file mode %v", info.Mode().Perm())
+	}
+	_, err = OpenWithOptions(name, opts...)
+	if portErr, ok := err.(*PortError); !ok || portErr.Code() != PortBusy {
+		t.Fatalf("expected PortBusy error, got %v", err)
+	} else if lockErr, ok := portErr.causedBy.(*LockError); !ok || lockErr.PID != os.Getpid() || lockErr.LockFile != lockFile {
+		t.Fatalf("unexpected cause %v", portErr.causedBy)
+	}
+	port.Close()
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Fatalf("lock file not removed: %v", err)
+	}
+
+	// Stale lock files are replaced
+	if err := os.WriteFile(lockFile, []byte(fmt.Sprintf("%10d\n", math.MaxInt32)), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port, err = OpenWithOptions(name, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port.Close()
+
+	// Binary PIDs are supported
+	binaryPID := make([]byte, 4)
+	binary.NativeEndian.PutUint32(binaryPID, uint32(os.Getpid()))
+	if err := os.WriteFile(lockFile, binaryPID, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = OpenWithOptions(name, opts...)
+	if lockErr := (*LockError)(nil); !errors.As(err, &lockErr) || lockErr.PID != os.Getpid() {
+		t.Fatalf("expected LockError, got %v", err)
+	}
+
+	// Lock files without a valid PID (for example still being written)
+	// are never removed
+	defer func(d time.Duration) { lockFileRetryDelay = d }(lockFileRetryDelay)
+	lockFileRetryDelay = time.Millisecond
+	if err := os.WriteFile(lockFile, nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = OpenWithOptions(name, opts...)
+	if lockErr := (*LockError)(nil); !errors.As(err, &lockErr) || lockErr.PID != 0 || lockErr.LockFile != lockFile {
+		t.Fatalf("expected LockError, got %v", err)
+	} else if msg := lockErr.Error(); msg != "port locked by another process ("+lockFile+")" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	if _, err := os.Stat(lockFile); err != nil {
+		t.Fatalf("lock file removed: %v", err)
+	}
+}
+
+func TestFlock(t *testing.T) {
+	_, name := openPty(t)
+	opts := []Option{WithFlock(), WithExclusiveAccess(false)}
+	port, err := OpenWithOptions(name, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = OpenWithOptions(name, opts...)
+	if portErr, ok := err.(*PortError); !ok || portErr.Code() != PortBusy {
+		t.Fatalf("expected PortBusy error, got %v", err)
+	} else if lockErr, ok := portErr.causedBy.(*LockError); !ok || lockErr.PID != 0 || lockErr.LockFile != "" {
+		t.Fatalf("unexpected cause %v", portErr.causedBy)
+	}
+	port.Close()
+	port, err = OpenWithOptions(name, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port.Close()
+}
+
+func TestDiagnoseOpenError(t *testing.T) {
+	_, name := openPty(t)
+	port, err := Open(name, &Mode{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer port.Close()
+
+	d, err := DiagnoseOpenError(name, &PortError{code: PortBusy})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found := false
+	for _, holder := range d.Holders {
+		if holder.PID == os.Getpid() {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("this process is not listed as holder: %+v", d.Holders)
+	}
+	if d.Console || d.Getty {
+		t.Fatalf("unexpected diagnosis: %+v", d)
+	}
+
+	if _, err := DiagnoseOpenError("/dev/nonexistent-port", nil); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestErrorOpAndPort(t *testing.T) {
+	_, err := Open("/dev/nonexistent-port", &Mode{})
+	if !errors.Is(err, ErrPortNotFound) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected PortNotFound error, got %v", err)
+	}
+	if portErr := err.(*PortError); portErr.Op != "open" || portErr.Port != "/dev/nonexistent-port" {
+		t.Fatalf("unexpected error %#v", portErr)
+	}
+
+	_, name := openPty(t)
+	port, err := Open(name, &Mode{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := port.SetMode(&Mode{DataBits: 9}); !errors.Is(err, ErrInvalidDataBits) || err.(*PortError).Op != "set mode" {
+		t.Fatalf("expected InvalidDataBits error, got %v", err)
+	}
+	port.Close()
+	_, err = port.Read(make([]byte, 10))
+	if !errors.Is(err, ErrPortClosed) || err.(*PortError).Op != "read" || err.(*PortError).Port != name {
+		t.Fatalf("expected PortClosed error, got %v", err)
+	}
+}
+
+func TestDeviceRemoved(t *testing.T) {
+	master, name := openPty(t)
+	port, err := Open(name, &Mode{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer port.Close()
+
+	// Closing the master hangs up the slave side, like an unplugged device
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		master.Close()
+	}()
+	buf := make([]byte, 10)
+	if _, err := port.Read(buf); !errors.Is(err, ErrDeviceRemoved) {
+		t.Fatalf("expected DeviceRemoved error, got %v", err)
+	}
+	if _, err := port.Read(buf); !errors.Is(err, ErrDeviceRemoved) {
+		t.Fatalf("expected DeviceRemoved error, got %v", err)
+	}
+	if _, err := port.Write([]byte("hello")); !errors.Is(err, ErrDeviceRemoved) {
+		t.Fatalf("expected DeviceRemoved error, got %v", err)
+	}
+	if err := port.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := port.Read(buf); !errors.Is(err, ErrPortClosed) {
+		t.Fatalf("expected PortClosed error, got %v", err)
+	}
+}
+
+// ptyMatcher is a PortMatcher returning a fixed list of ports.
+type ptyMatcher []string
+
+func (m *ptyMatcher) MatchingPorts() ([]string, error) {
+	return *m, nil
+}
+
+func TestOpenMatching(t *testing.T) {
+	master, name, err := OpenPseudoTerminal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer master.Close()
+
+	matcher := &ptyMatcher{}
+	if _, err := OpenMatching(matcher, &Mode{}); !errors.Is(err, ErrPortNotFound) || err.(*PortError).Op != "open" {
+		t.Fatalf("expected PortNotFound, got %v", err)
+	}
+	*matcher = []string{name, "/dev/ttyFAKE"}
+	if _, err := OpenMatching(matcher, &Mode{}); !errors.Is(err, ErrMultiplePortsFound) || err.(*PortError).Op != "open" {
+		t.Fatalf("expected MultiplePortsFound, got %v", err)
+	}
+
+	// Wait until the port appears
+	*matcher = nil
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := OpenMatchingContext(ctx, matcher, &Mode{}); !errors.Is(err, ErrPortNotFound) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected PortNotFound, got %v", err)
+	}
+	found := &ptyMatcher{name}
+	waiting := &waitingMatcher{after: time.Now().Add(300 * time.Millisecond), matcher: found}
+	port, err := OpenMatchingContext(context.Background(), waiting, &Mode{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port.Close()
+	if time.Now().Before(waiting.after) {
+		t.Fatal("the port has been opened before appearing")
+	}
+}
+
+// waitingMatcher is a PortMatcher that doesn't match any port until a given time.
+type waitingMatcher struct {
+	after   time.Time
+	matcher PortMatcher
+}
+
+func (m *waitingMatcher) MatchingPorts() ([]string, error) {
+	if time.Now().Before(m.after) {
+		return nil, nil
+	}
+	return m.matcher.MatchingPorts()
+}
+
+func TestReadWriteContext(t *testing.T) {
+	t.Run("Select", func(t *testing.T) { testReadWriteContext(t) })
+	t.Run("RuntimePoller", func(t *testing.T) { testReadWriteContext(t, WithRuntimePoller()) })
+}
+
+func testReadWriteContext(t *testing.T, opts ...Option) {
+	master, name := openPty(t)
+	port, err := OpenWithOptions(name, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer port.Close()
+	buf := make([]byte, 10)
+
+	// An already cancelled context fails immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := port.(ContextReadWriter).ReadContext(ctx, buf); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Cancel a blocked ReadContext
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if n, err := port.(ContextReadWriter).ReadContext(ctx, buf); n != 0 || err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %d %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("ReadContext returned after %v", elapsed)
+	}
+
+	// The port still reads normally
+	if _, err := master.Write([]byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n, err := port.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("unexpected read %q %v", buf[:n], err)
+	}
+
+	// A WriteContext blocked by the full output buffer (the master is not
+	// read) returns when the context expires
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	n, err = port.(ContextReadWriter).WriteContext(ctx, make([]byte, 1<<20))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if n <= 0 || n >= 1<<20 {
+		t.Fatalf("expected a partial write, got %d bytes", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("WriteContext returned after %v", elapsed)
+	}
+
+	// The port still writes normally once the master reads the data
+	received := make(chan error, 1)
+	go func() {
+		var data []byte
+		buf := make([]byte, 4096)
+		for !strings.HasSuffix(string(data), "world") {
+			n, err := master.Read(buf)
+			if err != nil {
+				received <- err
+				return
+			}
+			data = append(data, buf[:n]...)
+		}
+		received <- nil
+	}()
+	if n, err := port.Write([]byte("world")); n != 5 || err != nil {
+		t.Fatalf("unexpected write %d %v", n, err)
+	}
+	if err := <-received; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestConnDeadlines(t *testing.T) {
+	t.Run("Select", func(t *testing.T) { testConnDeadlines(t) })
+	t.Run("RuntimePoller", func(t *testing.T) { testConnDeadlines(t, WithRuntimePoller()) })
+}
+
+func testConnDeadlines(t *testing.T, opts ...Option) {
+	master, name := openPty(t)
+	port, err := OpenWithOptions(name, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn := NewConn(port)
+	defer conn.Close()
+	buf := make([]byte, 10)
+
+	// An expired deadline
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = conn.Read(buf)
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || !os.IsTimeout(err) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a timeout net.OpError, got %v", err)
+	}
+
+	// Moving the deadline wakes up a blocked Read
+	conn.SetReadDeadline(time.Time{})
+	time.AfterFunc(20*time.Millisecond, func() { conn.SetReadDeadline(time.Now()) })
+	start := time.Now()
+	if _, err := conn.Read(buf); !os.IsTimeout(err) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Read returned after %v", elapsed)
+	}
+
+	// A zero deadline disables the timeout
+	conn.SetReadDeadline(time.Time{})
+	time.AfterFunc(50*time.Millisecond, func() { master.Write([]byte("hello")) })
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("unexpected read %q %v", buf[:n], err)
+	}
+
+	// Operations after Close
+	if err := conn.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := conn.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+	if _, err := conn.Write(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+	if err := conn.SetDeadline(time.Now()); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	t.Run("Select", func(t *testing.T) { testWriteTimeout(t) })
+	t.Run("RuntimePoller", func(t *testing.T) { testWriteTimeout(t, WithRuntimePoller()) })
+}
+
+func testWriteTimeout(t *testing.T, opts ...Option) {
+	// The master is never read, so the output buffer fills up
+	open := func() Port {
+		_, name := openPty(t)
+		port, err := OpenWithOptions(name, opts...)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return port
+	}
+	data := make([]byte, 1<<20)
+
+	port := open()
+	defer port.Close()
+	if err := port.(WriteTimeoutSetter).SetWriteTimeout(50 * time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n, err := port.Write(data)
+	if !errors.Is(err, ErrDeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if n <= 0 || n >= len(data) {
+		t.Fatalf("expected a partial write, got %d bytes", n)
+	}
+
+	// Close wakes up a blocked writer
+	port = open()
+	time.AfterFunc(50*time.Millisecond, func() { port.Close() })
+	start := time.Now()
+	n, err = port.Write(data)
+	if !errors.Is(err, ErrPortClosed) {
+		t.Fatalf("expected PortClosed, got %v", err)
+	}
+	if n <= 0 || n >= len(data) {
+		t.Fatalf("expected a partial write, got %d bytes", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Write returned after %v", elapsed)
+	}
+}
+
+func TestRS485ConfigAfterClose(t *testing.T) {
+	_, name := openPty(t)
+	port, err := Open(name, &Mode{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rs485 := port.(RS485Configurer)
+	if _, err := rs485.GetRS485Config(); !errors.Is(err, ErrFunctionNotImplemented) {
+		t.Fatalf("expected FunctionNotImplemented, got %v", err)
+	}
+	if err := rs485.SetRS485Config(nil); !errors.Is(err, ErrInvalidSerialPort) {
+		t.Fatalf("expected InvalidSerialPort, got %v", err)
+	}
+	port.Close()
+	if err := rs485.SetRS485Config(&RS485Config{Enabled: true}); !errors.Is(err, ErrPortClosed) {
+		t.Fatalf("expected PortClosed, got %v", err)
+	}
+	if _, err := rs485.GetRS485Config(); !errors.Is(err, ErrPortClosed) {
+		t.Fatalf("expected PortClosed, got %v", err)
+	}
+}
+
+func TestModemStatusWatcher(t *testing.T) {
+	_, name := openPty(t)
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port, err := Open(name, &Mode{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer port.Close()
+	watcher := port.(ModemStatusWatcher)
+
+	// Pseudo-terminals don't support the modem status lines
+	if _, err := watcher.WaitForModemStatusChange(context.Background(), ModemStatusCTS); !errors.Is(err, ErrFunctionNotImplemented) {
+		t.Fatalf("expected FunctionNotImplemented, got %v", err)
+	}
+	if _, err := watcher.ModemEvents(context.Background()); !errors.Is(err, ErrFunctionNotImplemented) {
+		t.Fatalf("expected FunctionNotImplemented, got %v", err)
+	}
+
+	// Simulate a driver that supports them, the lines never change. The
+	// simulated TIOCMIWAIT is interrupted by signals and then checks the
+	// descriptor, as the restarted ioctl does.
+	defer func(f func(int, *serialICounter) error) { ioctlGetICount = f }(ioctlGetICount)
+	ioctlGetICount = func(int, *serialICounter) error { return nil }
+	defer func(f func(int) error) { ioctlModemWait = f }(ioctlModemWait)
+	ioctlModemWait = func(fd int) error {
+		for {
+			unix.Poll(nil, -1)
+			if _, err := unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+				return err
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := watcher.WaitForModemStatusChange(ctx, ModemStatusCTS); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Close wakes up all the waiters
+	waitErr := make(chan error, 1)
+	go func() {
+		_, err := watcher.WaitForModemStatusChange(context.Background(), ModemStatusCTS|ModemStatusDCD)
+		waitErr <- err
+	}()
+	events, err := watcher.ModemEvents(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	port.Close()
+	select {
+	case err := <-waitErr:
+		if !errors.Is(err, ErrPortClosed) {
+			t.Fatalf("expected PortClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitForModemStatusChange not woken up by Close")
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("unexpected modem event")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ModemEvents channel not closed by Close")
+	}
+	if _, err := watcher.WaitForModemStatusChange(context.Background(), ModemStatusCTS); !errors.Is(err, ErrPortClosed) {
+		t.Fatalf("expected PortClosed, got %v", err)
+	}
+
+	// The descriptors of the port, including the one used by TIOCMIWAIT,
+	// are released
+	if after, err := os.ReadDir("/proc/self/fd"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(after) != len(fds) {
+		t.Fatalf("%d open file descriptors, expected %d", len(after), len(fds))
+	}
+}
+
+func TestLineStatisticsAfterClose(t *testing.T) {
+	t.Run("Select", func(t *testing.T) { testLineStatisticsAfterClose(t) })
+	t.Run("RuntimePoller", func(t *testing.T) { testLineStatisticsAfterClose(t, WithRuntimePoller()) })
+}
+
+func testLineStatisticsAfterClose(t *testing.T, opts ...Option) {
+	_, name := openPty(t)
+	port, err := OpenWithOptions(name, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stats := port.(LineStatisticsGetter)
+	if _, err := stats.GetLineStatistics(); !errors.Is(err, ErrFunctionNotImplemented) {
+		t.Fatalf("expected FunctionNotImplemented, got %v", err)
+	}
+	port.Close()
+	// Keep the descriptor of the port busy with another file
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	if _, err := stats.GetLineStatistics(); !errors.Is(err, ErrPortClosed) {
+		t.Fatalf("expected PortClosed, got %v", err)
+	}
+}
+
+func TestQueueInspector(t *testing.T) {
+	master, name := openPty(t)
+	port, err := Open(name, &Mode{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	queues := port.(QueueInspector)
+
+	// waitQueue waits until the queue reports the expected length
+	waitQueue := func(queue func() (int, error), expected int) {
+		t.Helper()
+		for start := time.Now(); ; time.Sleep(time.Millisecond) {
+			n, err := queue()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n == expected {
+				return
+			}
+			if time.Since(start) > time.Second {
+				t.Fatalf("expected %d bytes in the queue, got %d", expected, n)
+			}
+		}
+	}
+
+	waitQueue(queues.InputWaiting, 0)
+	if _, err := master.Write([]byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitQueue(queues.InputWaiting, 5)
+	buf := make([]byte, 10)
+	if n, err := port.Read(buf); n != 5 || err != nil {
+		t.Fatalf("unexpected read %d %v", n, err)
+	}
+	waitQueue(queues.InputWaiting, 0)
+
+	if _, err := port.Write([]byte("world")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := master.Read(buf); n != 5 || err != nil {
+		t.Fatalf("unexpected read %d %v", n, err)
+	}
+	waitQueue(queues.OutputWaiting, 0)
+
+	port.Close()
+	if _, err := queues.InputWaiting(); !errors.Is(err, ErrPortClosed) {
+		t.Fatalf("expected PortClosed, got %v", err)
+	}
+	if _, err := queues.OutputWaiting(); !errors.Is(err, ErrPortClosed) {
+		t.Fatalf("expected PortClosed, got %v", err)
+	}
+}
+
+func TestCanonicalEOF(t *testing.T) {
+	t.Run("Select", func(t *testing.T) { testCanonicalEOF(t) })
+	t.Run("RuntimePoller", func(t *testing.T) { testCanonicalEOF(t, WithRuntimePoller()) })
+}
+
+func testCanonicalEOF(t *testing.T, opts ...Option) {
+	master, name := openPty(t)
+	// The slave of a new pty is in canonical mode
+	port, err := OpenWithOptions(name, append(opts, WithKeepTermSettings())...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer port.Close()
+
+	// ^D is the end of file, not the disconnection of the device
+	if _, err := master.Write([]byte{4}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := make([]byte, 10)
+	if n, err := port.Read(buf); n != 0 || err != nil {
+		t.Fatalf("unexpected read %d %v", n, err)
+	}
+	if _, err := master.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := port.Read(buf); err != nil || string(buf[:n]) != "hello\n" {
+		t.Fatalf("unexpected read %q %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,147 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build linux || darwin || freebsd || openbsd
+
+package serial
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// createLockFile creates the UUCP lock file of the port in dir, it returns
+// the path of the lock file.
+func createLockFile(portName, dir string) (string, error) {
+	// The lock file is named after the device, not after the symlinks
+	// pointing to it (like the ones in /dev/serial/by-id)
+	if resolved, err := filepath.EvalSymlinks(portName); err == nil {
+		portName = resolved
+	}
+	if dir == "" {
+		dir = lockFolder
+	}
+	path := filepath.Join(dir, "LCK.."+filepath.Base(portName))
+
+	// The lock file is written to a temporary file and then linked, so
+	// other processes never see a lock file without the PID. The temporary
+	// file is created with mode 0600, but the lock file must be readable by
+	// the other processes to check the PID.
+	tmp, err := os.CreateTemp(dir, "LTMP.")
+	if err != nil {
+		return "", lockFileError(err)
+	}
+	defer os.Remove(tmp.Name())
+	err = tmp.Chmod(0644)
+	if err == nil {
+		_, err = fmt.Fprintf(tmp, "%10d\n", os.Getpid())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", lockFileError(err)
+	}
+
+	// Retry a few times, in case a stale lock is removed concurrently
+	// by another process or the lock file is being written
+	for i := 0; i < 3; i++ {
+		err := os.Link(tmp.Name(), path)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", lockFileError(err)
+		}
+
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", lockFileError(err)
+		}
+		pid, ok := parseLockFile(data)
+		if !ok {
+			// The lock file may be still written by its owner, it's
+			// never removed without a valid PID
+			time.Sleep(lockFileRetryDelay)
+			continue
+		}
+		if processExists(pid) {
+			return "", &PortError{code: PortBusy, causedBy: &LockError{LockFile: path, PID: pid}}
+		}
+		// Stale lock file
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", lockFileError(err)
+		}
+	}
+	return "", &PortError{code: PortBusy, causedBy: &LockError{LockFile: path}}
+}
+
+// lockFileRetryDelay is the time to wait before reading again a lock file
+// that doesn't contain a valid PID.
+var lockFileRetryDelay = 50 * time.Millisecond
+
+// parseLockFile returns the PID written in a lock file, either in ASCII or
+// as a 4 bytes binary integer (used by old UUCP implementations).
+func parseLockFile(data []byte) (int, bool) {
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+		return pid, pid > 0
+	}
+	if len(data) == 4 {
+		pid := int(int32(binary.NativeEndian.Uint32(data)))
+		return pid, pid > 0
+	}
+	return 0, false
+}
+
+func lockFileError(err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return &PortError{code: PermissionDenied, causedBy: fmt.Errorf("error creating lock file: %w", err)}
+	}
+	return &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error creating lock file: %w", err)}
+}
+
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// EPERM means that the process exists but belongs to another user
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
+
+// acquireFlock places an exclusive flock on the port. flock doesn't report
+// the process holding the lock, so the PID of the LockError is always 0.
+func (port *unixPort) acquireFlock() error {
+	if err := unix.Flock(port.handle, unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		if err == unix.EWOULDBLOCK {
+			return &PortError{code: PortBusy, causedBy: &LockError{}}
+		}
+		return &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error locking port: %w", err)}
+	}
+	port.flocked = true
+	return nil
+}
+
+// releaseLocks releases the exclusive access and the flock of the port (if
+// acquired), it must be called before the file descriptor is closed.
+func (port *unixPort) releaseLocks() {
+	if port.exclusive {
+		port.releaseExclusiveAccess()
+	}
+	if port.flocked {
+		unix.Flock(port.handle, unix.LOCK_UN)
+	}
+}
@@ -0,0 +1,397 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialICounter mirrors the kernel struct serial_icounter_struct (see linux/serial.h)
+type serialICounter struct {
+	CTS, DSR, RNG, DCD          uint32
+	RX, TX                      uint32
+	Frame, Overrun, Parity, Brk uint32
+	BufOverrun                  uint32
+	Reserved                    [9]uint32
+}
+
+// changedLines returns the modem status lines with a different transition
+// counter in c and cur
+func (c *serialICounter) changedLines(cur *serialICounter) ModemStatusMask {
+	var lines ModemStatusMask
+	if c.CTS != cur.CTS {
+		lines |= ModemStatusCTS
+	}
+	if c.DSR != cur.DSR {
+		lines |= ModemStatusDSR
+	}
+	if c.RNG != cur.RNG {
+		lines |= ModemStatusRI
+	}
+	if c.DCD != cur.DCD {
+		lines |= ModemStatusDCD
+	}
+	return lines
+}
+
+// modemState is the state of the modem status lines used to detect their
+// changes: the transition counters of the driver or, if the driver doesn't
+// support TIOCGICOUNT, the status of the lines.
+type modemState struct {
+	counter *serialICounter // nil if TIOCGICOUNT isn't supported
+	status  int             // TIOCMGET bits, if counter is nil
+}
+
+// changedLines returns the modem status lines that changed between s and cur
+func (s *modemState) changedLines(cur *modemState) ModemStatusMask {
+	if s.counter != nil && cur.counter != nil {
+		return s.counter.changedLines(cur.counter)
+	}
+	diff := s.status ^ cur.status
+	var lines ModemStatusMask
+	if diff&unix.TIOCM_CTS != 0 {
+		lines |= ModemStatusCTS
+	}
+	if diff&unix.TIOCM_DSR != 0 {
+		lines |= ModemStatusDSR
+	}
+	if diff&unix.TIOCM_RNG != 0 {
+		lines |= ModemStatusRI
+	}
+	if diff&unix.TIOCM_CD != 0 {
+		lines |= ModemStatusDCD
+	}
+	return lines
+}
+
+// ioctlGetICount reads the counters of the driver with TIOCGICOUNT (it's a
+// variable so the tests can simulate a driver that supports it).
+var ioctlGetICount = func(fd int, c *serialICounter) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCGICOUNT), uintptr(unsafe.Pointer(c)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ioctlModemWait waits with TIOCMIWAIT for a change of the modem status lines
+// (it's a variable so the tests can simulate a driver that supports it).
+var ioctlModemWait = func(fd int) error {
+	return unix.IoctlSetInt(fd, unix.TIOCMIWAIT, unix.TIOCM_CTS|unix.TIOCM_DSR|unix.TIOCM_RNG|unix.TIOCM_CD)
+}
+
+func (port *unixPort) getICounter() (*serialICounter, error) {
+	c := &serialICounter{}
+	if err := ioctlGetICount(port.handle, c); err != nil {
+		return nil, modemIoctlError(err)
+	}
+	return c, nil
+}
+
+func (port *unixPort) GetLineStatistics() (*LineStatistics, error) {
+	stats, err := port.getLineStatistics()
+	if err != nil {
+		return nil, port.opError("get line statistics", err)
+	}
+	return stats, nil
+}
+
+func (port *unixPort) getModemState() (*modemState, error) {
+	if c, err := port.getICounter(); err == nil {
+		return &modemState{counter: c}, nil
+	}
+	status, err := port.getModemBitsStatus()
+	if err != nil {
+		return nil, modemIoctlError(err)
+	}
+	return &modemState{status: status}, nil
+}
+
+func (port *unixPort) getLineStatistics() (*LineStatistics, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return nil, &PortError{code: PortClosed}
+	}
+
+	c, err := port.getICounter()
+	if err != nil {
+		return nil, err
+	}
+	return &LineStatistics{
+		RX:             c.RX,
+		TX:             c.TX,
+		FrameErrors:    c.Frame,
+		ParityErrors:   c.Parity,
+		Overruns:       c.Overrun,
+		BufferOverruns: c.BufOverrun,
+		Breaks:         c.Brk,
+		CTSChanges:     c.CTS,
+		DSRChanges:     c.DSR,
+		RIChanges:      c.RNG,
+		DCDChanges:     c.DCD,
+	}, nil
+}
+
+func modemIoctlError(err error) error {
+	if err == unix.ENOTTY || err == unix.EINVAL {
+		// The driver of the port doesn't support the ioctl
+		return &PortError{code: FunctionNotImplemented, causedBy: err}
+	}
+	return &PortError{code: InvalidSerialPort, causedBy: err}
+}
+
+func (port *unixPort) WaitForModemStatusChange(ctx context.Context, mask ModemStatusMask) (*ModemStatusBits, error) {
+	status, err := port.waitForModemStatusChange(ctx, mask)
+	return status, withOp(err, "wait for modem status change", port.name)
+}
+
+func (port *unixPort) waitForModemStatusChange(ctx context.Context, mask ModemStatusMask) (*ModemStatusBits, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return nil, &PortError{code: PortClosed}
+	}
+
+	start, err := port.getModemState()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := port.waitModemStatusChange(ctx, mask, start); err != nil {
+		return nil, err
+	}
+	return port.GetModemStatusBits()
+}
+
+func (port *unixPort) ModemEvents(ctx context.Context) (<-chan ModemEvent, error) {
+	events, err := port.modemEvents(ctx)
+	return events, withOp(err, "modem events", port.name)
+}
+
+func (port *unixPort) modemEvents(ctx context.Context) (<-chan ModemEvent, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return nil, &PortError{code: PortClosed}
+	}
+
+	prev, err := port.getModemState()
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan ModemEvent)
+	go func() {
+		defer close(events)
+		for {
+			event, cur, err := port.nextModemEvent(ctx, prev)
+			if err != nil {
+				return
+			}
+			select {
+			case events <- *event:
+			case <-ctx.Done():
+				return
+			case <-port.closed:
+				return
+			}
+			prev = cur
+		}
+	}()
+	return events, nil
+}
+
+func (port *unixPort) nextModemEvent(ctx context.Context, prev *modemState) (*ModemEvent, *modemState, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return nil, nil, &PortError{code: PortClosed}
+	}
+
+	allLines := ModemStatusCTS | ModemStatusDSR | ModemStatusRI | ModemStatusDCD
+	cur, changed, err := port.waitModemStatusChange(ctx, allLines, prev)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	status, err := port.GetModemStatusBits()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &ModemEvent{Time: now, Changed: changed, Status: *status}, cur, nil
+}
+
+// waitModemStatusChange blocks until the state of the lines selected by mask
+// differs from prev, and returns the new state together with the lines that
+// changed. It must be called with the closeLock held.
+func (port *unixPort) waitModemStatusChange(ctx context.Context, mask ModemStatusMask, prev *modemState) (*modemState, ModemStatusMask, error) {
+	for {
+		// Start watching before reading the state, so no change
+		// between the two is missed
+		changed, err := port.watchModemStatus()
+		if err != nil {
+			return nil, 0, err
+		}
+		cur, err := port.getModemState()
+		if err != nil {
+			return nil, 0, err
+		}
+		if lines := prev.changedLines(cur) & mask; lines != 0 {
+			return cur, lines, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-port.closed:
+			return nil, 0, &PortError{code: PortClosed}
+		}
+	}
+}
+
+// watchModemStatus returns a channel that is closed at the next change of the
+// modem status lines, starting the modem watcher if it's not running.
+func (port *unixPort) watchModemStatus() (<-chan struct{}, error) {
+	port.modemLock.Lock()
+	defer port.modemLock.Unlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return nil, &PortError{code: PortClosed}
+	}
+	if port.modem == nil {
+		modem, err := startModemWatcher(port.handle)
+		if err != nil {
+			return nil, err
+		}
+		port.modem = modem
+	}
+	return port.modem.wait()
+}
+
+// stopModemWatcher stops the modem watcher, if running. It's called by Close.
+func (port *unixPort) stopModemWatcher() {
+	port.modemLock.Lock()
+	modem := port.modem
+	port.modem = nil
+	port.modemLock.Unlock()
+	if modem != nil {
+		modem.stop()
+	}
+}
+
+// modemWatcher waits for the changes of the modem status lines with TIOCMIWAIT
+// and signals them to the waiters, until it's stopped or an error occurs. The
+// ioctl runs on a locked thread and on a duplicate of the descriptor of the
+// port, so it can be interrupted by stop.
+type modemWatcher struct {
+	tid  int           // the thread running TIOCMIWAIT
+	done chan struct{} // closed when the watcher exits
+
+	mu      sync.Mutex
+	fd      int           // -1 after the watcher exits
+	changed chan struct{} // closed at the next change of the lines
+	err     error         // the error that stopped the watcher
+	stopped bool
+}
+
+func startModemWatcher(handle int) (*modemWatcher, error) {
+	fd, err := unix.FcntlInt(uintptr(handle), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	w := &modemWatcher{
+		fd:      fd,
+		done:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	started := make(chan struct{})
+	go w.run(started)
+	<-started
+	return w, nil
+}
+
+func (w *modemWatcher) run(started chan<- struct{}) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	w.tid = unix.Gettid()
+	close(started)
+
+	for {
+		err := ioctlModemWait(w.fd)
+		if err == unix.EINTR {
+			continue
+		}
+
+		w.mu.Lock()
+		close(w.changed)
+		if w.stopped {
+			err = &PortError{code: PortClosed}
+		} else if err != nil {
+			err = modemIoctlError(err)
+		}
+		if err != nil {
+			w.err = err
+			unix.Close(w.fd)
+			w.fd = -1
+			close(w.done)
+		} else {
+			w.changed = make(chan struct{})
+		}
+		w.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wait returns a channel that is closed at the next change of the lines.
+func (w *modemWatcher) wait() (<-chan struct{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.changed, nil
+}
+
+// stop interrupts the TIOCMIWAIT and waits for the watcher to exit.
+//
+// The ioctl is restarted by the kernel after a signal, and closing the
+// descriptor doesn't wake it up. So the descriptor is replaced with a file
+// that doesn't support TIOCMIWAIT before sending a signal to the thread: the
+// restarted ioctl fails with ENOTTY and the tty is released. SIGURG is used
+// since the Go runtime already sends it to preempt goroutines.
+func (w *modemWatcher) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	interrupted := false
+	if w.fd != -1 {
+		if null, err := unix.Eventfd(0, unix.EFD_CLOEXEC); err == nil {
+			interrupted = unix.Dup3(null, w.fd, unix.O_CLOEXEC) == nil
+			unix.Close(null)
+		}
+	}
+	w.mu.Unlock()
+	if !interrupted {
+		// The watcher exits at the next change of the lines
+		return
+	}
+
+	for {
+		unix.Tgkill(unix.Getpid(), w.tid, unix.SIGURG)
+		select {
+		case <-w.done:
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
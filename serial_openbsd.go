@@ -13,6 +13,7 @@ import (
 )
 
 const devFolder = "/dev"
+const lockFolder = "/var/spool/lock"
 
 var osPortFilter = regexp.MustCompile("^(cu|tty)\\..*")
 
@@ -57,12 +58,14 @@ const tcCCTS_OFLOW uint32 = 0x00010000
 const tcCRTS_IFLOW uint32 = 0x00020000
 
 const tcCRTSCTS uint32 = tcCCTS_OFLOW
+const tcCDTRDSR uint32 = 0 // DTR/DSR flow control is not supported
 
 const ioctlTcgetattr = unix.TIOCGETA
 const ioctlTcsetattr = unix.TIOCSETA
 const ioctlTcflsh = unix.TIOCFLUSH
 const ioctlTioccbrk = unix.TIOCCBRK
 const ioctlTiocsbrk = unix.TIOCSBRK
+const ioctlFionread = 0x4004667f // FIONREAD
 
 func toTermiosSpeedType(speed uint32) int32 {
 	return int32(speed)
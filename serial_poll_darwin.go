@@ -0,0 +1,69 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"fmt"
+	"time"
+
+	"go.bug.st/serial/unixutils"
+	"golang.org/x/sys/unix"
+)
+
+// pollReady performs a single Select on the port, returns true if the port
+// is ready or false if the select returned for any other reason.
+// MacOSX doesn't support devices in poll, so select is used instead: the file
+// descriptors must be below FD_SETSIZE, otherwise an error is returned.
+func (port *unixPort) pollReady(write bool, until time.Time, changed *deadlineSignal, cancel *cancelSignal) (bool, error) {
+	fds := []int{port.handle, port.closeSignal.ReadFD(), changed.ReadFD()}
+	if cancel != nil {
+		fds = append(fds, cancel.ReadFD())
+	}
+	for _, fd := range fds {
+		if fd >= unix.FD_SETSIZE {
+			return false, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("file descriptor %d exceeds FD_SETSIZE (%d)", fd, unix.FD_SETSIZE)}
+		}
+	}
+
+	rd := unixutils.NewFDSet(port.closeSignal.ReadFD(), changed.ReadFD())
+	if cancel != nil {
+		rd.Add(cancel.ReadFD())
+	}
+	var wr *unixutils.FDSet
+	if write {
+		wr = unixutils.NewFDSet(port.handle)
+	} else {
+		rd.Add(port.handle)
+	}
+	er := unixutils.NewFDSet(port.handle, port.closeSignal.ReadFD())
+
+	timeout := time.Duration(-1)
+	if !until.IsZero() {
+		timeout = time.Until(until)
+		if timeout < 0 {
+			// a negative timeout means "no-timeout" in Select(...)
+			timeout = 0
+		}
+	}
+	res, err := unixutils.Select(rd, wr, er, timeout)
+	if err == unix.EINTR {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if res.IsReadable(port.closeSignal.ReadFD()) {
+		return false, &PortError{code: PortClosed}
+	}
+	if cancel != nil && res.IsReadable(cancel.ReadFD()) {
+		return false, cancel.ctx.Err()
+	}
+	if write {
+		return res.IsWritable(port.handle), nil
+	}
+	return res.IsReadable(port.handle), nil
+}
@@ -0,0 +1,60 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build linux || freebsd || openbsd
+
+package serial
+
+import (
+	"time"
+
+	"go.bug.st/serial/unixutils"
+	"golang.org/x/sys/unix"
+)
+
+// pollReady performs a single Poll on the port, returns true if the port is
+// ready or false if the poll returned for any other reason.
+func (port *unixPort) pollReady(write bool, until time.Time, changed *deadlineSignal, cancel *cancelSignal) (bool, error) {
+	events := int16(unix.POLLIN)
+	if write {
+		events = unix.POLLOUT
+	}
+	fds := [4]unix.PollFd{
+		{Fd: int32(port.handle), Events: events},
+		{Fd: int32(port.closeSignal.ReadFD()), Events: unix.POLLIN},
+		{Fd: int32(changed.ReadFD()), Events: unix.POLLIN},
+	}
+	nfds := 3
+	if cancel != nil {
+		fds[3] = unix.PollFd{Fd: int32(cancel.ReadFD()), Events: unix.POLLIN}
+		nfds = 4
+	}
+
+	timeout := time.Duration(-1)
+	if !until.IsZero() {
+		timeout = time.Until(until)
+		if timeout < 0 {
+			// a negative timeout means "no-timeout" in Poll(...)
+			timeout = 0
+		}
+	}
+	_, err := unixutils.Poll(fds[:nfds], timeout)
+	if err == unix.EINTR {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if fds[1].Revents != 0 {
+		return false, &PortError{code: PortClosed}
+	}
+	if cancel != nil && fds[3].Revents != 0 {
+		return false, cancel.ctx.Err()
+	}
+	// An error (or hangup) is reported as ready, so it's returned by the
+	// following read or write
+	return fds[0].Revents != 0, nil
+}
@@ -0,0 +1,232 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build linux || darwin || freebsd || openbsd
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// runtimePoller holds the state of a port that waits for I/O through the
+// poller of the Go runtime (see WithRuntimePoller). The deadlines of the file
+// are updated to interrupt the pending operations when their timeout expires,
+// the deadlines of the port are changed or their context is done.
+type runtimePoller struct {
+	file *os.File
+	conn syscall.RawConn
+
+	// The pending read and write operations, guarded by deadlineLock
+	read, write pollerOp
+}
+
+type pollerOp struct {
+	timeout  time.Time
+	canceled bool
+}
+
+// aLongTimeAgo is a deadline in the past, used to interrupt an operation
+var aLongTimeAgo = time.Unix(1, 0)
+
+func (port *unixPort) startRuntimePoller() error {
+	// The port must already be in non-blocking mode, so NewFile adds it to
+	// the runtime poller (never call file.Fd, it restores blocking mode).
+	file := os.NewFile(uintptr(port.handle), port.name)
+	port.poller = &runtimePoller{file: file}
+
+	// If the device is not supported by the runtime poller NewFile falls
+	// back to blocking I/O, that doesn't support deadlines
+	if err := file.SetDeadline(time.Time{}); err != nil {
+		return &PortError{code: FunctionNotImplemented, causedBy: err}
+	}
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	port.poller.conn = conn
+	return nil
+}
+
+func (port *unixPort) pollerRead(ctx context.Context, p []byte, timeout time.Time) (int, error) {
+	if port.deadlineExpired(false) {
+		return 0, &PortError{code: DeadlineExceeded}
+	}
+	// Try to read before waiting: the poller doesn't even try if the
+	// timeout is already expired
+	if n, err := readNonblock(port.handle, p); err != unix.EAGAIN {
+		return port.readResult(n, err)
+	}
+
+	defer port.beginPollerOp(ctx, false, timeout)()
+	var n int
+	var readErr error
+	for {
+		err := port.poller.conn.Read(func(fd uintptr) bool {
+			n, readErr = readNonblock(int(fd), p)
+			return readErr != unix.EAGAIN
+		})
+		if err == nil {
+			return port.readResult(n, readErr)
+		}
+		if retry, err := port.pollerError(ctx, false, timeout, err); !retry {
+			return 0, err
+		}
+	}
+}
+
+func readNonblock(fd int, p []byte) (int, error) {
+	for {
+		n, err := unix.Read(fd, p)
+		if err != unix.EINTR {
+			return n, err
+		}
+	}
+}
+
+func (port *unixPort) pollerWrite(ctx context.Context, p []byte, timeout time.Time) (int, error) {
+	// Try to write before waiting: the poller doesn't even try if the
+	// timeout is already expired
+	written, writeErr := writeNonblock(port.handle, p)
+	if writeErr != unix.EAGAIN {
+		return written, writeErr
+	}
+
+	defer port.beginPollerOp(ctx, true, timeout)()
+	for {
+		err := port.poller.conn.Write(func(fd uintptr) bool {
+			var n int
+			n, writeErr = writeNonblock(int(fd), p[written:])
+			written += n
+			return writeErr != unix.EAGAIN
+		})
+		if err == nil {
+			return written, writeErr
+		}
+		if retry, err := port.pollerError(ctx, true, timeout, err); !retry {
+			if err == nil {
+				// The write timeout expired
+				err = &PortError{code: DeadlineExceeded}
+			}
+			return written, err
+		}
+	}
+}
+
+// writeNonblock writes p until all the data is written or an error occurs
+// (EAGAIN included)
+func writeNonblock(fd int, p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := unix.Write(fd, p[written:])
+		if n > 0 {
+			written += n
+		}
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// pollerError translates an error returned by the runtime poller, it returns
+// true if the operation must be retried because the deadline has been moved.
+// A nil error is returned if the timeout of the operation expired.
+func (port *unixPort) pollerError(ctx context.Context, write bool, timeout time.Time, err error) (bool, error) {
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return false, &PortError{code: PortClosed}
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false, err
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if port.deadlineExpired(write) {
+		return false, &PortError{code: DeadlineExceeded}
+	}
+	if !timeout.IsZero() && !time.Now().Before(timeout) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// beginPollerOp sets the deadline of the file for an operation with the given
+// timeout and arranges its cancellation when the context is done. It returns
+// the function to call at the end of the operation.
+func (port *unixPort) beginPollerOp(ctx context.Context, write bool, timeout time.Time) func() {
+	port.deadlineLock.Lock()
+	op := port.pollerOp(write)
+	op.timeout = timeout
+	op.canceled = false
+	port.applyPollerDeadline(write)
+	port.deadlineLock.Unlock()
+
+	var stop func() bool
+	var canceled chan struct{}
+	if ctx.Done() != nil {
+		canceled = make(chan struct{})
+		stop = context.AfterFunc(ctx, func() {
+			port.deadlineLock.Lock()
+			port.pollerOp(write).canceled = true
+			port.applyPollerDeadline(write)
+			port.deadlineLock.Unlock()
+			close(canceled)
+		})
+	}
+	return func() {
+		if stop != nil && !stop() {
+			// Wait for the cancellation, so it can't hit the next operation
+			<-canceled
+		}
+		port.deadlineLock.Lock()
+		op := port.pollerOp(write)
+		op.timeout = time.Time{}
+		op.canceled = false
+		port.applyPollerDeadline(write)
+		port.deadlineLock.Unlock()
+	}
+}
+
+func (port *unixPort) pollerOp(write bool) *pollerOp {
+	if write {
+		return &port.poller.write
+	}
+	return &port.poller.read
+}
+
+// applyPollerDeadline sets the deadline of the file to the earliest between
+// the deadline of the port and the timeout of the pending operation, or in
+// the past if the operation is canceled or the port is closed. It must be
+// called with the deadlineLock held.
+func (port *unixPort) applyPollerDeadline(write bool) {
+	op := port.pollerOp(write)
+	deadline := port.readDeadline
+	if write {
+		deadline = port.writeDeadline
+	}
+	if !op.timeout.IsZero() && (deadline.IsZero() || op.timeout.Before(deadline)) {
+		deadline = op.timeout
+	}
+	if op.canceled || atomic.LoadUint32(&port.opened) != 1 {
+		deadline = aLongTimeAgo
+	}
+	if write {
+		port.poller.file.SetWriteDeadline(deadline)
+	} else {
+		port.poller.file.SetReadDeadline(deadline)
+	}
+}
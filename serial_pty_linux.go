@@ -0,0 +1,123 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenPseudoTerminal creates a new pseudo-terminal and returns its master
+// side as a Port, together with the name of the slave device (for example
+// /dev/pts/3). The slave device can be opened with Open like any other serial
+// port, so it can be used to simulate a device in tests. The options are
+// applied as with OpenWithOptions: the mode is set on the slave side, that is
+// put in raw mode.
+//
+// The master port keeps the slave side open until it's closed, so reading
+// from the master doesn't fail while the slave device is not (yet) opened by
+// anyone. The modem status bits are not supported by pseudo-terminals.
+func OpenPseudoTerminal(opts ...Option) (Port, string, error) {
+	port, slaveName, err := openPseudoTerminal(newOpenOptions(opts))
+	if err != nil {
+		// Return a nil interface, for which var==nil is true (instead of
+		// a nil pointer to a struct that satisfies the interface).
+		return nil, "", withOp(err, "open", "/dev/ptmx")
+	}
+	return port, slaveName, nil
+}
+
+func openPseudoTerminal(options *openOptions) (*unixPort, string, error) {
+	h, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_NDELAY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		switch err {
+		case unix.EACCES:
+			return nil, "", &PortError{code: PermissionDenied}
+		case unix.ENOENT:
+			return nil, "", &PortError{code: FunctionNotImplemented, causedBy: err}
+		}
+		return nil, "", &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	if err := unix.IoctlSetPointerInt(h, unix.TIOCSPTLCK, 0); err != nil {
+		unix.Close(h)
+		return nil, "", &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error unlocking pseudo-terminal: %w", err)}
+	}
+	n, err := unix.IoctlGetUint32(h, unix.TIOCGPTN)
+	if err != nil {
+		unix.Close(h)
+		return nil, "", &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error getting pseudo-terminal number: %w", err)}
+	}
+	slaveName := fmt.Sprintf("/dev/pts/%d", n)
+	slave, err := unix.Open(slaveName, unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		unix.Close(h)
+		return nil, "", &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error opening pseudo-terminal slave: %w", err)}
+	}
+
+	port, err := newUnixPort(h, "/dev/ptmx", options)
+	if err != nil {
+		unix.Close(slave)
+		return nil, "", err
+	}
+	port.ptySlave = os.NewFile(uintptr(slave), slaveName)
+	return port, slaveName, nil
+}
+
+// NullModemPair is a pair of pseudo-terminals linked to each other like two
+// serial ports connected by a null-modem cable: the data written to one of
+// the devices is received by the other one. Only the data is forwarded, the
+// modem status bits are not emulated.
+type NullModemPair struct {
+	// Names contains the names of the two linked devices, that can be
+	// opened with Open.
+	Names [2]string
+
+	masters [2]*unixPort
+	wg      sync.WaitGroup
+}
+
+// OpenNullModemPair creates a new NullModemPair. The pair must be closed with
+// Close when no longer needed.
+func OpenNullModemPair() (*NullModemPair, error) {
+	pair := &NullModemPair{}
+	for i := range pair.masters {
+		master, name, err := openPseudoTerminal(newOpenOptions(nil))
+		if err != nil {
+			if i == 1 {
+				pair.masters[0].Close()
+			}
+			return nil, err
+		}
+		pair.masters[i] = master
+		pair.Names[i] = name
+	}
+	pair.wg.Add(2)
+	go pair.forward(pair.masters[1], pair.masters[0])
+	go pair.forward(pair.masters[0], pair.masters[1])
+	return pair, nil
+}
+
+func (pair *NullModemPair) forward(dst, src *unixPort) {
+	defer pair.wg.Done()
+	// The copy ends with an error when the pair is closed
+	io.Copy(dst, src)
+}
+
+// Close closes both devices of the pair.
+func (pair *NullModemPair) Close() error {
+	err0 := pair.masters[0].Close()
+	err1 := pair.masters[1].Close()
+	pair.wg.Wait()
+	if err0 != nil {
+		return err0
+	}
+	return err1
+}
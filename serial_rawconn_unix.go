@@ -0,0 +1,100 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build linux || darwin || freebsd || openbsd
+
+package serial
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// SyscallConn returns a raw connection to the file descriptor of the port,
+// it implements the syscall.Conn interface. The functions passed to the
+// methods of the raw connection are called while holding the port open, so
+// the file descriptor can't be closed (or reused) under them; Close waits
+// until they return.
+func (port *unixPort) SyscallConn() (syscall.RawConn, error) {
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return nil, &PortError{code: PortClosed}
+	}
+	return &rawConn{port: port}, nil
+}
+
+// rawConn implements syscall.RawConn for a unixPort. The read and write
+// functions are retried until they return true, waiting for the port to be
+// ready in between: the wait is interrupted by Close and by the deadlines of
+// the port, but not by the read or write timeouts.
+type rawConn struct {
+	port *unixPort
+}
+
+func (c *rawConn) Control(f func(fd uintptr)) error {
+	port := c.port
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return &PortError{code: PortClosed}
+	}
+	f(uintptr(port.handle))
+	return nil
+}
+
+func (c *rawConn) Read(f func(fd uintptr) bool) error {
+	return c.waitAndCall(false, f)
+}
+
+func (c *rawConn) Write(f func(fd uintptr) bool) error {
+	return c.waitAndCall(true, f)
+}
+
+func (c *rawConn) waitAndCall(write bool, f func(fd uintptr) bool) error {
+	port := c.port
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return &PortError{code: PortClosed}
+	}
+
+	if port.poller != nil {
+		for {
+			var err error
+			if write {
+				err = port.poller.conn.Write(f)
+			} else {
+				err = port.poller.conn.Read(f)
+			}
+			if err == nil {
+				return nil
+			}
+			if atomic.LoadUint32(&port.opened) != 1 {
+				return &PortError{code: PortClosed}
+			}
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				return err
+			}
+			if port.deadlineExpired(write) {
+				return &PortError{code: DeadlineExceeded}
+			}
+			// The deadline of the file has been set by the timeout of a
+			// concurrent Read or Write, try again
+		}
+	}
+
+	for !f(uintptr(port.handle)) {
+		if port.deadlineExpired(write) {
+			return &PortError{code: DeadlineExceeded}
+		}
+		if _, err := port.waitReady(write, time.Time{}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,9 +11,9 @@ package serial
 import "golang.org/x/sys/unix"
 
 func (port *unixPort) ResetInputBuffer() error {
-	return unix.IoctlSetInt(port.handle, ioctlTcflsh, unix.TCIFLUSH)
+	return port.opError("reset input buffer", unix.IoctlSetInt(port.handle, ioctlTcflsh, unix.TCIFLUSH))
 }
 
 func (port *unixPort) ResetOutputBuffer() error {
-	return unix.IoctlSetInt(port.handle, ioctlTcflsh, unix.TCOFLUSH)
+	return port.opError("reset output buffer", unix.IoctlSetInt(port.handle, ioctlTcflsh, unix.TCOFLUSH))
 }
@@ -0,0 +1,53 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build linux || darwin || freebsd || openbsd
+
+package serial
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// savedSettings is the state of the tty when the port has been opened, that
+// is restored by Close (see WithRestoreOnClose)
+type savedSettings struct {
+	termios   *unix.Termios
+	modemBits int
+	hasModem  bool // false if the tty doesn't support the modem bits (ptys)
+}
+
+func (port *unixPort) saveSettings() error {
+	termios, err := port.getTermSettings()
+	if err != nil {
+		return &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error saving term settings: %w", err)}
+	}
+	port.saved = &savedSettings{termios: termios}
+	if bits, err := port.getModemBitsStatus(); err == nil {
+		port.saved.modemBits = bits
+		port.saved.hasModem = true
+	}
+	return nil
+}
+
+// restoreSettings puts back the settings saved when the port was opened (if
+// any), it must be called before the file descriptor is closed.
+func (port *unixPort) restoreSettings() error {
+	if port.saved == nil {
+		return nil
+	}
+	if err := port.setTermSettings(port.saved.termios); err != nil {
+		return &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error restoring term settings: %w", err)}
+	}
+	if port.saved.hasModem {
+		if err := port.setModemBitsStatus(port.saved.modemBits); err != nil {
+			return &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error restoring modem bits status: %w", err)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialRS485 mirrors the kernel struct serial_rs485 (see linux/serial.h)
+type serialRS485 struct {
+	Flags              uint32
+	DelayRTSBeforeSend uint32 // milliseconds
+	DelayRTSAfterSend  uint32 // milliseconds
+	Padding            [5]uint32
+}
+
+const (
+	serRS485Enabled      uint32 = 1 << 0
+	serRS485RTSOnSend    uint32 = 1 << 1
+	serRS485RTSAfterSend uint32 = 1 << 2
+	serRS485RxDuringTx   uint32 = 1 << 4
+	serRS485TerminateBus uint32 = 1 << 5
+)
+
+func (port *unixPort) SetRS485Config(config *RS485Config) error {
+	if config == nil {
+		return port.opError("set RS485 config", errors.New("nil RS485 config"))
+	}
+	rs485 := &serialRS485{
+		DelayRTSBeforeSend: toRS485Delay(config.DelayRTSBeforeSend),
+		DelayRTSAfterSend:  toRS485Delay(config.DelayRTSAfterSend),
+	}
+	if config.Enabled {
+		rs485.Flags |= serRS485Enabled
+	}
+	if config.RTSOnSend {
+		rs485.Flags |= serRS485RTSOnSend
+	}
+	if config.RTSAfterSend {
+		rs485.Flags |= serRS485RTSAfterSend
+	}
+	if config.RxDuringTx {
+		rs485.Flags |= serRS485RxDuringTx
+	}
+	if config.TerminateBus {
+		rs485.Flags |= serRS485TerminateBus
+	}
+	return port.opError("set RS485 config", port.ioctlRS485(unix.TIOCSRS485, rs485))
+}
+
+func (port *unixPort) GetRS485Config() (*RS485Config, error) {
+	rs485 := &serialRS485{}
+	if err := port.ioctlRS485(unix.TIOCGRS485, rs485); err != nil {
+		return nil, port.opError("get RS485 config", err)
+	}
+	return &RS485Config{
+		Enabled:            rs485.Flags&serRS485Enabled != 0,
+		RTSOnSend:          rs485.Flags&serRS485RTSOnSend != 0,
+		RTSAfterSend:       rs485.Flags&serRS485RTSAfterSend != 0,
+		DelayRTSBeforeSend: time.Duration(rs485.DelayRTSBeforeSend) * time.Millisecond,
+		DelayRTSAfterSend:  time.Duration(rs485.DelayRTSAfterSend) * time.Millisecond,
+		RxDuringTx:         rs485.Flags&serRS485RxDuringTx != 0,
+		TerminateBus:       rs485.Flags&serRS485TerminateBus != 0,
+	}, nil
+}
+
+func (port *unixPort) ioctlRS485(req uint, rs485 *serialRS485) error {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return &PortError{code: PortClosed}
+	}
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(port.handle), uintptr(req), uintptr(unsafe.Pointer(rs485)))
+	switch errno {
+	case 0:
+		return nil
+	case unix.ENOTTY:
+		// The driver of the port doesn't support RS485
+		return &PortError{code: FunctionNotImplemented, causedBy: errno}
+	default:
+		return &PortError{code: InvalidSerialPort, causedBy: errno}
+	}
+}
+
+// toRS485Delay converts d to milliseconds, rounding up
+func toRS485Delay(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	ms := d / time.Millisecond
+	if d%time.Millisecond != 0 {
+		ms++
+	}
+	if ms > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(ms)
+}
@@ -0,0 +1,50 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPortError(t *testing.T) {
+	err := error(&PortError{Op: "open", Port: "/dev/ttyUSB0", code: PermissionDenied, causedBy: os.ErrPermission})
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("%v is not ErrPermissionDenied", err)
+	}
+	if errors.Is(err, ErrPortBusy) {
+		t.Fatalf("%v is ErrPortBusy", err)
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("%v doesn't wrap os.ErrPermission", err)
+	}
+	if msg := err.Error(); msg != "open /dev/ttyUSB0: Permission denied: permission denied" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	if msg := ErrPortClosed.Error(); msg != "Port has been closed" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+func TestWithOp(t *testing.T) {
+	shared := &PortError{code: PortClosed}
+	err := withOp(shared, "read", "/dev/ttyUSB0")
+	if portErr := err.(*PortError); portErr.Op != "read" || portErr.Port != "/dev/ttyUSB0" || portErr.Code() != PortClosed {
+		t.Fatalf("unexpected error %+v", portErr)
+	}
+	if shared.Op != "" || shared.Port != "" {
+		t.Fatalf("the original error has been modified: %+v", shared)
+	}
+	// The operation already set is kept
+	if err := withOp(err, "write", "/dev/ttyUSB1"); err.(*PortError).Op != "read" {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if err := withOp(os.ErrPermission, "open", "/dev/ttyUSB0"); err != os.ErrPermission {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
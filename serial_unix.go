@@ -9,7 +9,9 @@
 package serial
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -22,116 +24,490 @@ import (
 
 type unixPort struct {
 	handle int
+	name   string
 
-	readTimeout time.Duration
-	closeLock   sync.RWMutex
-	closeSignal *unixutils.Pipe
-	opened      uint32
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	closeLock    sync.RWMutex
+	closeSignal  *unixutils.Pipe
+	closed       chan struct{}
+	opened       uint32
+	removed      uint32         // set to 1 when the device is disconnected
+	poller       *runtimePoller // not nil if the port uses the runtime poller
+	ptySlave     *os.File       // slave side kept open by a pseudo-terminal master
+	saved        *savedSettings // settings restored by Close (if not nil)
+	exclusive    bool           // true if exclusive access has been acquired
+	flocked      bool           // true if the flock has been acquired
+	lockFile     string         // the UUCP lock file, if created
+
+	deadlineLock    sync.Mutex
+	readDeadline    time.Time
+	writeDeadline   time.Time
+	deadlineChanged *deadlineSignal
+
+	// errorMark is the status of the bytes marked with a receive error (or
+	// ByteOK if Mode.ReportByteStatus is not set)
+	errorMark   uint32
+	markPending []byte // incomplete PARMRK sequence left by the previous read
+
+	modemLock sync.Mutex
+	modem     *modemWatcher // the watcher of the modem status lines, if started
 }
 
 func (port *unixPort) Close() error {
 	if !atomic.CompareAndSwapUint32(&port.opened, 1, 0) {
 		return nil
 	}
+	close(port.closed)
+	port.stopModemWatcher()
+	if port.ptySlave != nil {
+		defer port.ptySlave.Close()
+	}
+	if port.lockFile != "" {
+		// Remove the lock file after the port is closed
+		defer os.Remove(port.lockFile)
+	}
 
-	// Close port
-	port.releaseExclusiveAccess()
-	if err := unix.Close(port.handle); err != nil {
-		return err
+	if port.poller != nil {
+		// Wake up all pending reads and writes (if any) and wait for them
+		// to complete before closing the port
+		port.deadlineLock.Lock()
+		port.applyPollerDeadline(false)
+		port.applyPollerDeadline(true)
+		port.deadlineLock.Unlock()
+		port.closeLock.Lock()
+		defer port.closeLock.Unlock()
+
+		restoreErr := port.restoreSettings()
+		port.releaseLocks()
+		if err := port.poller.file.Close(); err != nil {
+			return err
+		}
+		return restoreErr
 	}
 
 	if port.closeSignal != nil {
-		// Send close signal to all pending reads (if any)
+		// Send close signal to all pending reads and writes (if any)
 		port.closeSignal.Write([]byte{0})
 
-		// Wait for all readers to complete
+		// Wait for all readers and writers to complete before closing
+		// the port, so they never use a closed (or reused) file handle
 		port.closeLock.Lock()
 		defer port.closeLock.Unlock()
+	}
 
-		// Close signaling pipe
+	// Close port
+	restoreErr := port.restoreSettings()
+	port.releaseLocks()
+	err := unix.Close(port.handle)
+	if err == nil {
+		err = restoreErr
+	}
+
+	if port.closeSignal != nil {
+		// Close signaling pipes
+		port.deadlineLock.Lock()
+		if port.deadlineChanged != nil {
+			port.deadlineChanged.Close()
+			port.deadlineChanged = nil
+		}
+		port.deadlineLock.Unlock()
 		if err := port.closeSignal.Close(); err != nil {
 			return err
 		}
 	}
-	return nil
+	return err
 }
 
 func (port *unixPort) Read(p []byte) (int, error) {
+	return port.ReadContext(context.Background(), p)
+}
+
+func (port *unixPort) ReadContext(ctx context.Context, p []byte) (int, error) {
+	n, err := port.read(ctx, p, nil)
+	return n, withOp(port.deviceError(err), "read", port.name)
+}
+
+func (port *unixPort) ReadWithStatus(p []byte, status []ByteStatus) (int, error) {
+	if len(status) < len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	n, err := port.read(context.Background(), p, status)
+	return n, withOp(port.deviceError(err), "read", port.name)
+}
+
+func (port *unixPort) read(ctx context.Context, p []byte, status []ByteStatus) (int, error) {
 	port.closeLock.RLock()
 	defer port.closeLock.RUnlock()
 	if atomic.LoadUint32(&port.opened) != 1 {
 		return 0, &PortError{code: PortClosed}
 	}
+	if err := port.checkRemoved(); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
-	var deadline time.Time
+	var timeout time.Time
 	if port.readTimeout != NoTimeout {
-		deadline = time.Now().Add(port.readTimeout)
+		timeout = time.Now().Add(port.readTimeout)
 	}
 
-	fds := unixutils.NewFDSet(port.handle, port.closeSignal.ReadFD())
-	for {
-		timeout := time.Duration(-1)
-		if port.readTimeout != NoTimeout {
-			timeout = time.Until(deadline)
-			if timeout < 0 {
-				// a negative timeout means "no-timeout" in Select(...)
-				timeout = 0
-			}
-		}
-		res, err := unixutils.Select(fds, nil, fds, timeout)
-		if err == unix.EINTR {
-			continue
+	var cancel *cancelSignal
+	if port.poller == nil {
+		var err error
+		if cancel, err = newCancelSignal(ctx); err != nil {
+			return 0, err
 		}
-		if err != nil {
+		defer cancel.Close()
+	}
+
+	for {
+		n, err := port.readRaw(ctx, p, timeout, cancel)
+		if n == 0 {
+			// Error or timeout
 			return 0, err
 		}
-		if res.IsReadable(port.closeSignal.ReadFD()) {
-			return 0, &PortError{code: PortClosed}
+		if errorMark := ByteStatus(atomic.LoadUint32(&port.errorMark)); errorMark != ByteOK {
+			n = port.decodeErrorMarks(p, n, status, errorMark)
+			if n == 0 && err == nil {
+				// Only part of an escape sequence has been received
+				continue
+			}
+		} else {
+			port.markPending = nil
+			for i := 0; i < n && status != nil; i++ {
+				status[i] = ByteOK
+			}
 		}
-		if !res.IsReadable(port.handle) {
+		return n, err
+	}
+}
+
+// readRaw performs a single read from the port, waiting until some data is
+// available. It returns 0 bytes and no error if the timeout expires.
+func (port *unixPort) readRaw(ctx context.Context, p []byte, timeout time.Time, cancel *cancelSignal) (int, error) {
+	if port.poller != nil {
+		return port.pollerRead(ctx, p, timeout)
+	}
+	for {
+		if ready, err := port.waitReady(false, timeout, cancel); err != nil {
+			return 0, err
+		} else if !ready {
 			// Timeout happened
 			return 0, nil
 		}
 		n, err := unix.Read(port.handle, p)
+		if err == unix.EINTR || err == unix.EAGAIN {
+			continue
+		}
+		return port.readResult(n, err)
+	}
+}
+
+func (port *unixPort) readResult(n int, err error) (int, error) {
+	// Linux: when the port is disconnected during a read operation
+	// the port is left in a "readable with zero-length-data" state.
+	// https://stackoverflow.com/a/34945814/1655275
+	// A zero-length read is also the end of file (^D) of a tty left in
+	// canonical mode (see WithKeepTermSettings), that isn't an error.
+	if n == 0 && err == nil {
+		if settings, err := port.getTermSettings(); err == nil && settings.Lflag&unix.ICANON != 0 {
+			return 0, nil
+		}
+		return 0, &PortError{code: DeviceRemoved}
+	}
+	if n < 0 { // Do not return -1 unix errors
+		n = 0
+	}
+	return n, err
+}
+
+// opError returns err as a PortError reporting the operation and the name of
+// the port, system errors are reported as InvalidSerialPort.
+func (port *unixPort) opError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	err = port.deviceError(err)
+	if _, ok := err.(*PortError); !ok {
+		err = &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	return withOp(err, op, port.name)
+}
+
+// deviceError returns a DeviceRemoved error if err is caused by the
+// disconnection of the device, and marks the port as removed so the
+// following operations fail immediately. Other errors are returned unchanged.
+func (port *unixPort) deviceError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case unix.EIO, unix.ENXIO:
+		err = &PortError{code: DeviceRemoved, causedBy: err}
+	}
+	if portErr, ok := err.(*PortError); ok && portErr.code == DeviceRemoved {
+		atomic.StoreUint32(&port.removed, 1)
+	}
+	return err
+}
+
+// checkRemoved returns a DeviceRemoved error if the device of the port has
+// been disconnected.
+func (port *unixPort) checkRemoved() error {
+	if atomic.LoadUint32(&port.removed) != 0 {
+		return &PortError{code: DeviceRemoved}
+	}
+	return nil
+}
+
+func (port *unixPort) Write(p []byte) (int, error) {
+	return port.WriteContext(context.Background(), p)
+}
+
+func (port *unixPort) WriteContext(ctx context.Context, p []byte) (int, error) {
+	n, err := port.write(ctx, p)
+	return n, withOp(port.deviceError(err), "write", port.name)
+}
+
+func (port *unixPort) write(ctx context.Context, p []byte) (int, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return 0, &PortError{code: PortClosed}
+	}
+	if err := port.checkRemoved(); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if port.deadlineExpired(true) {
+		return 0, &PortError{code: DeadlineExceeded}
+	}
+
+	var timeout time.Time
+	if port.writeTimeout != NoTimeout {
+		timeout = time.Now().Add(port.writeTimeout)
+	}
+
+	if port.poller != nil {
+		return port.pollerWrite(ctx, p, timeout)
+	}
+
+	cancel, err := newCancelSignal(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel.Close()
+
+	written := 0
+	for written < len(p) {
+		n, err := unix.Write(port.handle, p[written:])
+		if n > 0 {
+			written += n
+		}
 		if err == unix.EINTR {
 			continue
 		}
-		// Linux: when the port is disconnected during a read operation
-		// the port is left in a "readable with zero-length-data" state.
-		// https://stackoverflow.com/a/34945814/1655275
-		if n == 0 && err == nil {
-			return 0, &PortError{code: PortClosed}
+		if err == unix.EAGAIN {
+			// The output buffer is full, wait until there is room for more data
+			if ready, err := port.waitReady(true, timeout, cancel); err != nil {
+				return written, err
+			} else if !ready {
+				return written, &PortError{code: DeadlineExceeded}
+			}
+			continue
 		}
-		if n < 0 { // Do not return -1 unix errors
-			n = 0
+		if err != nil {
+			return written, err
 		}
-		return n, err
 	}
+	return written, nil
 }
 
-func (port *unixPort) Write(p []byte) (n int, err error) {
-	n, err = unix.Write(port.handle, p)
-	if n < 0 { // Do not return -1 unix errors
-		n = 0
+// waitReady blocks until the port is ready to be read (or written if write
+// is true). It returns false if the timeout expires before the port is ready,
+// a zero timeout means no timeout. A DeadlineExceeded error is returned if the
+// read (or write) deadline of the port expires, a PortClosed error if the port
+// is closed and the context error if the cancel signal fires.
+func (port *unixPort) waitReady(write bool, timeout time.Time, cancel *cancelSignal) (bool, error) {
+	for {
+		deadline, changed, err := port.watchDeadline(write)
+		if err != nil {
+			return false, err
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			port.unwatchDeadline(changed)
+			return false, &PortError{code: DeadlineExceeded}
+		}
+		until := timeout
+		if until.IsZero() || (!deadline.IsZero() && deadline.Before(until)) {
+			until = deadline
+		}
+		ready, err := port.pollReady(write, until, changed, cancel)
+		port.unwatchDeadline(changed)
+		if err != nil || ready {
+			return ready, err
+		}
+		if !timeout.IsZero() && !time.Now().Before(timeout) {
+			return false, nil
+		}
+		// The deadline has been changed or expired, the wait has been
+		// interrupted, check again.
+	}
+}
+
+func (port *unixPort) SetDeadline(t time.Time) error {
+	return port.setDeadline(true, true, t)
+}
+
+func (port *unixPort) SetReadDeadline(t time.Time) error {
+	return port.setDeadline(true, false, t)
+}
+
+func (port *unixPort) SetWriteDeadline(t time.Time) error {
+	return port.setDeadline(false, true, t)
+}
+
+func (port *unixPort) setDeadline(read, write bool, t time.Time) error {
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return &PortError{code: PortClosed}
+	}
+	port.deadlineLock.Lock()
+	defer port.deadlineLock.Unlock()
+	if read {
+		port.readDeadline = t
+	}
+	if write {
+		port.writeDeadline = t
+	}
+	if port.poller != nil {
+		port.applyPollerDeadline(false)
+		port.applyPollerDeadline(true)
+		return nil
+	}
+	// Wake up pending operations (if any) so they can pick up the new deadline
+	if s := port.deadlineChanged; s != nil && s.waiters > 0 {
+		s.Write([]byte{0})
+		s.fired = true
+		port.deadlineChanged = nil
+	}
+	return nil
+}
+
+func (port *unixPort) deadlineExpired(write bool) bool {
+	port.deadlineLock.Lock()
+	defer port.deadlineLock.Unlock()
+	deadline := port.readDeadline
+	if write {
+		deadline = port.writeDeadline
+	}
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// watchDeadline returns the current read (or write) deadline and a signal
+// that fires when any deadline is changed. The signal must be released with
+// unwatchDeadline.
+func (port *unixPort) watchDeadline(write bool) (time.Time, *deadlineSignal, error) {
+	port.deadlineLock.Lock()
+	defer port.deadlineLock.Unlock()
+	if port.deadlineChanged == nil {
+		pipe, err := unixutils.NewPipe()
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		port.deadlineChanged = &deadlineSignal{Pipe: pipe}
+	}
+	port.deadlineChanged.waiters++
+	if write {
+		return port.writeDeadline, port.deadlineChanged, nil
 	}
-	return
+	return port.readDeadline, port.deadlineChanged, nil
+}
+
+func (port *unixPort) unwatchDeadline(s *deadlineSignal) {
+	port.deadlineLock.Lock()
+	defer port.deadlineLock.Unlock()
+	s.waiters--
+	if s.fired && s.waiters == 0 {
+		s.Close()
+	}
+}
+
+// deadlineSignal is a pipe used to wake up the pending operations when a
+// deadline is changed. A fired signal is replaced by a new one and closed by
+// its last waiter, so the pipe never needs to be drained.
+type deadlineSignal struct {
+	*unixutils.Pipe
+	waiters int
+	fired   bool
+}
+
+// cancelSignal is a pipe that becomes readable when a context is done, it's
+// used to interrupt a blocking waitReady.
+type cancelSignal struct {
+	*unixutils.Pipe
+	ctx  context.Context
+	stop func() bool
+	sent chan struct{}
+}
+
+// newCancelSignal creates a cancelSignal for the given context. If the context
+// can never be cancelled no signal is needed and nil is returned.
+func newCancelSignal(ctx context.Context) (*cancelSignal, error) {
+	if ctx.Done() == nil {
+		return nil, nil
+	}
+	pipe, err := unixutils.NewPipe()
+	if err != nil {
+		return nil, err
+	}
+	s := &cancelSignal{Pipe: pipe, ctx: ctx, sent: make(chan struct{})}
+	s.stop = context.AfterFunc(ctx, func() {
+		s.Write([]byte{0})
+		close(s.sent)
+	})
+	return s, nil
+}
+
+// Close releases the signal, it's safe to call on a nil cancelSignal.
+func (s *cancelSignal) Close() error {
+	if s == nil {
+		return nil
+	}
+	if !s.stop() {
+		// The signal is being sent, wait for it before closing the pipe
+		<-s.sent
+	}
+	return s.Pipe.Close()
+}
+
+func (port *unixPort) PortName() string {
+	return port.name
 }
 
 func (port *unixPort) Break(t time.Duration) error {
 	if err := unix.IoctlSetInt(port.handle, ioctlTiocsbrk, 0); err != nil {
-		return err
+		return port.opError("break", err)
 	}
 
 	time.Sleep(t)
 
 	if err := unix.IoctlSetInt(port.handle, ioctlTioccbrk, 0); err != nil {
-		return err
+		return port.opError("break", err)
 	}
 
 	return nil
 }
 
 func (port *unixPort) SetMode(mode *Mode) error {
+	return port.opError("set mode", port.setMode(mode))
+}
+
+func (port *unixPort) setMode(mode *Mode) error {
 	settings, err := port.getTermSettings()
 	if err != nil {
 		return err
@@ -145,6 +521,10 @@ func (port *unixPort) SetMode(mode *Mode) error {
 	if err := setTermSettingsStopBits(mode.StopBits, settings); err != nil {
 		return err
 	}
+	if err := setTermSettingsFlowControl(mode, settings); err != nil {
+		return err
+	}
+	setTermSettingsByteStatus(mode, settings)
 	requireSpecialBaudrate := false
 	if err, special := setTermSettingsBaudrate(mode.BaudRate, settings); err != nil {
 		return err
@@ -154,6 +534,7 @@ func (port *unixPort) SetMode(mode *Mode) error {
 	if err := port.setTermSettings(settings); err != nil {
 		return err
 	}
+	port.setErrorMark(mode)
 	if requireSpecialBaudrate {
 		// MacOSX require this one to be the last operation otherwise an
 		// 'Invalid serial port' error is produced.
@@ -165,27 +546,22 @@ func (port *unixPort) SetMode(mode *Mode) error {
 }
 
 func (port *unixPort) SetDTR(dtr bool) error {
-	status, err := port.getModemBitsStatus()
-	if err != nil {
-		return err
-	}
-	if dtr {
-		status |= unix.TIOCM_DTR
-	} else {
-		status &^= unix.TIOCM_DTR
-	}
-	return port.setModemBitsStatus(status)
+	return port.opError("set DTR", port.setModemBit(unix.TIOCM_DTR, dtr))
 }
 
 func (port *unixPort) SetRTS(rts bool) error {
+	return port.opError("set RTS", port.setModemBit(unix.TIOCM_RTS, rts))
+}
+
+func (port *unixPort) setModemBit(bit int, value bool) error {
 	status, err := port.getModemBitsStatus()
 	if err != nil {
 		return err
 	}
-	if rts {
-		status |= unix.TIOCM_RTS
+	if value {
+		status |= bit
 	} else {
-		status &^= unix.TIOCM_RTS
+		status &^= bit
 	}
 	return port.setModemBitsStatus(status)
 }
@@ -198,10 +574,39 @@ func (port *unixPort) SetReadTimeout(timeout time.Duration) error {
 	return nil
 }
 
+func (port *unixPort) SetWriteTimeout(timeout time.Duration) error {
+	if timeout < 0 && timeout != NoTimeout {
+		return &PortError{code: InvalidTimeoutValue}
+	}
+	port.writeTimeout = timeout
+	return nil
+}
+
+func (port *unixPort) InputWaiting() (int, error) {
+	n, err := port.queueLength(ioctlFionread)
+	return n, port.opError("input waiting", err)
+}
+
+func (port *unixPort) OutputWaiting() (int, error) {
+	n, err := port.queueLength(unix.TIOCOUTQ)
+	return n, port.opError("output waiting", err)
+}
+
+// queueLength returns the number of bytes in the queue of the driver
+// selected by req (TIOCINQ or TIOCOUTQ).
+func (port *unixPort) queueLength(req uint) (int, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return 0, &PortError{code: PortClosed}
+	}
+	return unix.IoctlGetInt(port.handle, req)
+}
+
 func (port *unixPort) GetModemStatusBits() (*ModemStatusBits, error) {
 	status, err := port.getModemBitsStatus()
 	if err != nil {
-		return nil, err
+		return nil, port.opError("get modem status bits", err)
 	}
 	return &ModemStatusBits{
 		CTS: (status & unix.TIOCM_CTS) != 0,
@@ -211,42 +616,150 @@ func (port *unixPort) GetModemStatusBits() (*ModemStatusBits, error) {
 	}, nil
 }
 
-func nativeOpen(portName string, mode *Mode) (*unixPort, error) {
-	h, err := unix.Open(portName, unix.O_RDWR|unix.O_NOCTTY|unix.O_NDELAY, 0)
+func nativeOpen(portName string, options *openOptions) (*unixPort, error) {
+	flags := unix.O_RDWR | unix.O_NOCTTY
+	if options.nonblockingOpen {
+		flags |= unix.O_NDELAY
+	}
+
+	// The lock file must be created before opening the port, that may
+	// change the modem lines of a port in use
+	var lockFile string
+	if options.lockFile {
+		var err error
+		if lockFile, err = createLockFile(portName, options.lockDir); err != nil {
+			return nil, err
+		}
+	}
+
+	h, err := unix.Open(portName, flags, 0)
 	if err != nil {
+		if lockFile != "" {
+			os.Remove(lockFile)
+		}
 		switch err {
 		case unix.EBUSY:
-			return nil, &PortError{code: PortBusy}
+			return nil, &PortError{code: PortBusy, causedBy: err}
 		case unix.EACCES:
-			return nil, &PortError{code: PermissionDenied}
+			return nil, &PortError{code: PermissionDenied, causedBy: err}
+		case unix.ENOENT:
+			return nil, &PortError{code: PortNotFound, causedBy: err}
+		}
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	port, err := newUnixPort(h, portName, options)
+	if err != nil {
+		if lockFile != "" {
+			os.Remove(lockFile)
 		}
 		return nil, err
 	}
-	port := &unixPort{
-		handle:      h,
-		opened:      1,
-		readTimeout: NoTimeout,
+	port.lockFile = lockFile
+	return port, nil
+}
+
+// NewPortFromFile returns a Port for an already open tty, for example one
+// inherited from the parent process, received over a unix socket or the
+// slave side of a pseudo-terminal. The port is set up as if it was opened
+// with OpenWithOptions, using mode (if not nil) in place of the WithMode
+// option; use the WithKeepTermSettings option to leave the current settings
+// of the tty untouched.
+//
+// The port uses a duplicate of the file descriptor of f, so f can be closed
+// independently of the port. Note that the duplicate shares the file status
+// flags with f: the descriptor is switched to non-blocking mode.
+func NewPortFromFile(f *os.File, mode *Mode, opts ...Option) (Port, error) {
+	options := newOpenOptions(opts)
+	if mode != nil {
+		options.mode = mode
 	}
 
-	// Setup serial port
-	settings, err := port.getTermSettings()
+	// Don't use f.Fd(), it switches the file to blocking mode
+	conn, err := f.SyscallConn()
 	if err != nil {
-		port.Close()
-		return nil, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error getting term settings: %w", err)}
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	h := -1
+	var dupErr error
+	if err := conn.Control(func(fd uintptr) {
+		h, dupErr = unix.FcntlInt(fd, unix.F_DUPFD_CLOEXEC, 0)
+	}); err != nil {
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	if dupErr != nil {
+		return nil, &PortError{code: InvalidSerialPort, causedBy: dupErr}
 	}
 
-	// Set raw mode
-	setRawMode(settings)
+	port, err := newUnixPort(h, f.Name(), options)
+	if err != nil {
+		// Return a nil interface, for which var==nil is true (instead of
+		// a nil pointer to a struct that satisfies the interface).
+		return nil, withOp(err, "open", f.Name())
+	}
+	return port, nil
+}
 
-	// Explicitly disable RTS/CTS flow control
-	setTermSettingsCtsRts(false, settings)
+// newUnixPort sets up the open file descriptor h as a serial port, the file
+// descriptor is closed if an error occurs.
+func newUnixPort(h int, portName string, options *openOptions) (*unixPort, error) {
+	mode := options.mode
+	port := &unixPort{
+		handle:       h,
+		name:         portName,
+		opened:       1,
+		closed:       make(chan struct{}),
+		readTimeout:  NoTimeout,
+		writeTimeout: NoTimeout,
+	}
 
-	if err = port.setTermSettings(settings); err != nil {
-		port.Close()
-		return nil, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error setting term settings: %w", err)}
+	if options.flock {
+		if err := port.acquireFlock(); err != nil {
+			port.Close()
+			return nil, err
+		}
+	}
+
+	if options.restoreOnClose {
+		if err := port.saveSettings(); err != nil {
+			port.Close()
+			return nil, err
+		}
 	}
 
-	if mode.InitialStatusBits != nil {
+	if !options.keepTermSettings || options.hangupOnClose != nil || options.termSettingsHook != nil {
+		// Setup serial port
+		settings, err := port.getTermSettings()
+		if err != nil {
+			port.Close()
+			return nil, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error getting term settings: %w", err)}
+		}
+
+		if !options.keepTermSettings {
+			// Set raw mode
+			setRawMode(settings)
+
+			// Explicitly disable RTS/CTS flow control
+			setTermSettingsCtsRts(false, settings)
+		}
+
+		if options.hangupOnClose != nil {
+			setTermSettingsHangupOnClose(*options.hangupOnClose, settings)
+		}
+
+		if options.termSettingsHook != nil {
+			if err := options.termSettingsHook(settings); err != nil {
+				port.Close()
+				return nil, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error in term settings hook: %w", err)}
+			}
+		}
+
+		if err = port.setTermSettings(settings); err != nil {
+			port.Close()
+			return nil, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error setting term settings: %w", err)}
+		}
+	}
+
+	if mode.InitialStatusBits != nil && !options.keepModemLines {
 		status, err := port.getModemBitsStatus()
 		if err != nil {
 			port.Close()
@@ -270,14 +783,40 @@ func nativeOpen(portName string, mode *Mode) (*unixPort, error) {
 
 	// MacOSX require that this operation is the last one otherwise an
 	// 'Invalid serial port' error is returned... don't know why...
-	if err := port.SetMode(mode); err != nil {
+	if !options.keepTermSettings {
+		if err := port.setMode(mode); err != nil {
+			port.Close()
+			return nil, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error configuring port: %w", err)}
+		}
+	}
+
+	if err := port.SetReadTimeout(options.readTimeout); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error configuring port: %w", err)}
+		return nil, err
 	}
 
-	unix.SetNonblock(h, false)
+	// Keep the port in non-blocking mode: Read and Write wait for the port
+	// to be ready with a Poll, so they can be interrupted.
+	if err := unix.SetNonblock(h, true); err != nil {
+		port.Close()
+		return nil, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error setting non-blocking mode: %w", err)}
+	}
 
-	port.acquireExclusiveAccess()
+	if options.exclusiveAccess {
+		if err := port.acquireExclusiveAccess(); err != nil {
+			port.Close()
+			return nil, &PortError{code: InvalidSerialPort, causedBy: fmt.Errorf("error acquiring exclusive access: %w", err)}
+		}
+		port.exclusive = true
+	}
+
+	if options.runtimePoller {
+		if err := port.startRuntimePoller(); err != nil {
+			port.Close()
+			return nil, err
+		}
+		return port, nil
+	}
 
 	// This pipe is used as a signal to cancel blocking Read
 	if pipe, err := unixutils.NewPipe(); err != nil {
@@ -312,7 +851,7 @@ func nativeGetPortsList() ([]string, error) {
 
 		// Check if serial port is real or is a placeholder serial port "ttySxx" or "ttyHSxx"
 		if strings.HasPrefix(f.Name(), "ttyS") || strings.HasPrefix(f.Name(), "ttyHS") {
-			port, err := nativeOpen(portName, &Mode{})
+			port, err := nativeOpen(portName, newOpenOptions(nil))
 			if err != nil {
 				continue
 			} else {
@@ -402,6 +941,48 @@ func setTermSettingsCtsRts(enable bool, settings *unix.Termios) {
 	}
 }
 
+func setTermSettingsHangupOnClose(enable bool, settings *unix.Termios) {
+	if enable {
+		settings.Cflag |= unix.HUPCL
+	} else {
+		settings.Cflag &^= unix.HUPCL
+	}
+}
+
+func setTermSettingsFlowControl(mode *Mode, settings *unix.Termios) error {
+	// Remove previous flow control setting
+	setTermSettingsCtsRts(false, settings)
+	settings.Cflag &^= tcCDTRDSR
+	settings.Iflag &^= unix.IXON
+	settings.Iflag &^= unix.IXOFF
+	settings.Iflag &^= unix.IXANY
+
+	switch mode.FlowControl {
+	case NoFlowControl:
+	case RTSCTSFlowControl:
+		setTermSettingsCtsRts(true, settings)
+	case XONXOFFFlowControl:
+		settings.Iflag |= unix.IXON
+		settings.Iflag |= unix.IXOFF
+		settings.Cc[unix.VSTART] = defaultXonChar
+		if mode.XonChar != 0 {
+			settings.Cc[unix.VSTART] = mode.XonChar
+		}
+		settings.Cc[unix.VSTOP] = defaultXoffChar
+		if mode.XoffChar != 0 {
+			settings.Cc[unix.VSTOP] = mode.XoffChar
+		}
+	case DTRDSRFlowControl:
+		if tcCDTRDSR == 0 {
+			return &PortError{code: InvalidFlowControl}
+		}
+		settings.Cflag |= tcCDTRDSR
+	default:
+		return &PortError{code: InvalidFlowControl}
+	}
+	return nil
+}
+
 func setRawMode(settings *unix.Termios) {
 	// Set local mode
 	settings.Cflag |= unix.CREAD
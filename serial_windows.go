@@ -18,6 +18,7 @@ package serial
 */
 
 import (
+	"context"
 	"errors"
 	"strings"
 	"sync"
@@ -29,9 +30,13 @@ import (
 )
 
 type windowsPort struct {
-	mu         sync.Mutex
-	handle     windows.Handle
-	hasTimeout bool
+	mu            sync.Mutex
+	handle        windows.Handle
+	name          string
+	hasTimeout    bool
+	writeTimeout  uint32 // WriteTotalTimeoutConstant in milliseconds, 0 means no timeout
+	readDeadline  deadlineContext
+	writeDeadline deadlineContext
 }
 
 func nativeGetPortsList() ([]string, error) {
@@ -77,30 +82,31 @@ func (port *windowsPort) Close() error {
 }
 
 func (port *windowsPort) Read(p []byte) (int, error) {
-	var readed uint32
-	ev, err := createOverlappedEvent()
-	if err != nil {
+	return port.ReadContext(context.Background(), p)
+}
+
+func (port *windowsPort) ReadContext(ctx context.Context, p []byte) (int, error) {
+	n, err := port.read(ctx, p)
+	return n, withOp(port.deviceError(err), "read", port.name)
+}
+
+func (port *windowsPort) read(ctx context.Context, p []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
 		return 0, err
 	}
-	defer windows.CloseHandle(ev.HEvent)
-
 	for {
-		err = windows.ReadFile(port.handle, p, &readed, ev)
-		if err == windows.ERROR_IO_PENDING {
-			err = windows.GetOverlappedResult(port.handle, ev, &readed, true)
-		}
+		readed, err := port.overlappedIO(ctx, false, func(done *uint32, ov *windows.Overlapped) error {
+			return windows.ReadFile(port.handle, p, done, ov)
+		})
 		switch err {
-		case nil:
+		case nil, errDeadlineChanged:
 			// operation completed successfully
-		case windows.ERROR_OPERATION_ABORTED:
-			// port may have been closed
-			return int(readed), &PortError{code: PortClosed, causedBy: err}
 		default:
 			// error happened
-			return int(readed), err
+			return readed, err
 		}
 		if readed > 0 {
-			return int(readed), nil
+			return readed, nil
 		}
 
 		// Timeout
@@ -114,30 +120,242 @@ func (port *windowsPort) Read(p []byte) (int, error) {
 }
 
 func (port *windowsPort) Write(p []byte) (int, error) {
-	var writed uint32
+	return port.WriteContext(context.Background(), p)
+}
+
+func (port *windowsPort) WriteContext(ctx context.Context, p []byte) (int, error) {
+	n, err := port.write(ctx, p)
+	return n, withOp(port.deviceError(err), "write", port.name)
+}
+
+// deviceError returns a DeviceRemoved error if err reveals that the device of
+// the port has been disconnected (an USB adapter that is unplugged makes the
+// pending and the following operations fail with one of these errors), or a
+// PortClosed error if the operation has been aborted by Close.
+func (port *windowsPort) deviceError(err error) error {
+	switch err {
+	case windows.ERROR_OPERATION_ABORTED:
+		port.mu.Lock()
+		closed := port.handle == 0
+		port.mu.Unlock()
+		if closed {
+			return &PortError{code: PortClosed, causedBy: err}
+		}
+		return &PortError{code: DeviceRemoved, causedBy: err}
+	case windows.ERROR_DEVICE_NOT_CONNECTED, windows.ERROR_BAD_COMMAND, windows.ERROR_ACCESS_DENIED:
+		return &PortError{code: DeviceRemoved, causedBy: err}
+	}
+	return err
+}
+
+func (port *windowsPort) write(ctx context.Context, p []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	writed := 0
+	for {
+		n, err := port.overlappedIO(ctx, true, func(done *uint32, ov *windows.Overlapped) error {
+			return windows.WriteFile(port.handle, p[writed:], done, ov)
+		})
+		writed += n
+		if err == errDeadlineChanged {
+			// Send the remaining data
+			continue
+		}
+		if err == nil && writed < len(p) {
+			// The write timeout expired
+			err = &PortError{code: DeadlineExceeded}
+		}
+		return writed, err
+	}
+}
+
+// errDeadlineChanged is returned by overlappedIO when the operation has been
+// interrupted by a deadline change after some data has been transferred.
+var errDeadlineChanged = errors.New("deadline changed")
+
+// overlappedIO starts an overlapped operation with the issue function and
+// waits for its completion. The operation is aborted if ctx is done or if the
+// read (or write) deadline of the port expires, in that case the context error
+// or a DeadlineExceeded error is returned. If the operation is aborted because
+// the deadline has been changed, the operation is restarted or, if some data
+// has already been transferred, errDeadlineChanged is returned.
+func (port *windowsPort) overlappedIO(ctx context.Context, write bool, issue func(done *uint32, ov *windows.Overlapped) error) (int, error) {
 	ev, err := createOverlappedEvent()
 	if err != nil {
 		return 0, err
 	}
 	defer windows.CloseHandle(ev.HEvent)
-	err = windows.WriteFile(port.handle, p, &writed, ev)
-	if err == windows.ERROR_IO_PENDING {
-		// wait for write to complete
-		err = windows.GetOverlappedResult(port.handle, ev, &writed, true)
+
+	for {
+		deadline := port.deadlineContext(write)
+		if deadline.Err() == context.DeadlineExceeded {
+			return 0, &PortError{code: DeadlineExceeded}
+		}
+		var done uint32
+		cancel := newIOCanceller(port.handle, ev, ctx, deadline)
+		err := cancel.issue(func() error {
+			return issue(&done, ev)
+		})
+		if err == windows.ERROR_IO_PENDING {
+			err = windows.GetOverlappedResult(port.handle, ev, &done, true)
+		}
+		cancel.stop()
+		if err != windows.ERROR_OPERATION_ABORTED || !cancel.isCanceled() {
+			return int(done), err
+		}
+		if err := ctx.Err(); err != nil {
+			return int(done), err
+		}
+		if deadline.Err() == context.DeadlineExceeded {
+			return int(done), &PortError{code: DeadlineExceeded}
+		}
+		if done > 0 {
+			return int(done), errDeadlineChanged
+		}
+		// The deadline has been changed, try again with the new one
+	}
+}
+
+// ioCanceller cancels a pending overlapped operation when any of the given
+// contexts is done.
+type ioCanceller struct {
+	mu       sync.Mutex
+	canceled bool
+	stops    []func() bool
+	sent     []chan struct{} // closed when the cancellation of each context is done
+}
+
+func newIOCanceller(handle windows.Handle, ov *windows.Overlapped, ctxs ...context.Context) *ioCanceller {
+	c := &ioCanceller{}
+	for _, ctx := range ctxs {
+		sent := make(chan struct{})
+		c.sent = append(c.sent, sent)
+		c.stops = append(c.stops, context.AfterFunc(ctx, func() {
+			defer close(sent)
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.canceled = true
+			windows.CancelIoEx(handle, ov)
+		}))
+	}
+	return c
+}
+
+// issue starts an overlapped operation unless the context has already been
+// canceled, in that case ERROR_OPERATION_ABORTED is returned.
+func (c *ioCanceller) issue(op func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.canceled {
+		return windows.ERROR_OPERATION_ABORTED
+	}
+	return op()
+}
+
+func (c *ioCanceller) isCanceled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.canceled
+}
+
+// stop releases the canceller. If a cancellation is in progress it waits for
+// it, so a late CancelIoEx can't cancel the next operation on the handle.
+func (c *ioCanceller) stop() {
+	for i, stop := range c.stops {
+		if !stop() {
+			<-c.sent[i]
+		}
+	}
+}
+
+func (port *windowsPort) SetDeadline(t time.Time) error {
+	return port.setDeadline(true, true, t)
+}
+
+func (port *windowsPort) SetReadDeadline(t time.Time) error {
+	return port.setDeadline(true, false, t)
+}
+
+func (port *windowsPort) SetWriteDeadline(t time.Time) error {
+	return port.setDeadline(false, true, t)
+}
+
+func (port *windowsPort) setDeadline(read, write bool, t time.Time) error {
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	if port.handle == 0 {
+		return &PortError{code: PortClosed}
+	}
+	if read {
+		port.readDeadline.set(t)
+	}
+	if write {
+		port.writeDeadline.set(t)
+	}
+	return nil
+}
+
+// deadlineContext returns a context that expires with the read (or write)
+// deadline of the port. The context is canceled when the deadline is changed.
+func (port *windowsPort) deadlineContext(write bool) context.Context {
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	d := &port.readDeadline
+	if write {
+		d = &port.writeDeadline
+	}
+	if d.ctx == nil {
+		d.set(time.Time{})
+	}
+	return d.ctx
+}
+
+// deadlineContext holds a context that expires at a port deadline.
+type deadlineContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (d *deadlineContext) set(t time.Time) {
+	if d.cancel != nil {
+		// Wake up pending operations, they will pick up the new deadline
+		d.cancel()
+	}
+	if t.IsZero() {
+		d.ctx, d.cancel = context.WithCancel(context.Background())
+	} else {
+		d.ctx, d.cancel = context.WithDeadline(context.Background(), t)
 	}
-	return int(writed), err
+}
+
+func (port *windowsPort) PortName() string {
+	return port.name
+}
+
+// opError returns err as a PortError reporting the operation and the name of
+// the port, system errors are reported as InvalidSerialPort.
+func (port *windowsPort) opError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	err = port.deviceError(err)
+	if _, ok := err.(*PortError); !ok {
+		err = &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	return withOp(err, op, port.name)
 }
 
 func (port *windowsPort) Drain() (err error) {
-	return windows.FlushFileBuffers(port.handle)
+	return port.opError("drain", windows.FlushFileBuffers(port.handle))
 }
 
 func (port *windowsPort) ResetInputBuffer() error {
-	return windows.PurgeComm(port.handle, windows.PURGE_RXCLEAR|windows.PURGE_RXABORT)
+	return port.opError("reset input buffer", windows.PurgeComm(port.handle, windows.PURGE_RXCLEAR|windows.PURGE_RXABORT))
 }
 
 func (port *windowsPort) ResetOutputBuffer() error {
-	return windows.PurgeComm(port.handle, windows.PURGE_TXCLEAR|windows.PURGE_TXABORT)
+	return port.opError("reset output buffer", windows.PurgeComm(port.handle, windows.PURGE_TXCLEAR|windows.PURGE_TXABORT))
 }
 
 const (
@@ -176,20 +394,30 @@ var stopBitsMap = map[StopBits]byte{
 }
 
 func (port *windowsPort) SetMode(mode *Mode) error {
+	return withOp(port.setMode(mode), "set mode", port.name)
+}
+
+func (port *windowsPort) setMode(mode *Mode) error {
 	params := windows.DCB{}
-	if windows.GetCommState(port.handle, &params) != nil {
+	if err := windows.GetCommState(port.handle, &params); err != nil {
 		port.Close()
-		return &PortError{code: InvalidSerialPort}
+		return &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	if err := port.setModeParams(mode, &params); err != nil {
+		return err
 	}
-	port.setModeParams(mode, &params)
-	if windows.SetCommState(port.handle, &params) != nil {
+	if err := windows.SetCommState(port.handle, &params); err != nil {
 		port.Close()
-		return &PortError{code: InvalidSerialPort}
+		return &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 	return nil
 }
 
-func (port *windowsPort) setModeParams(mode *Mode, params *windows.DCB) {
+func (port *windowsPort) setModeParams(mode *Mode, params *windows.DCB) error {
+	if mode.ReportByteStatus {
+		// The receive status is not available for each byte
+		return &PortError{code: FunctionNotImplemented}
+	}
 	if mode.BaudRate == 0 {
 		params.BaudRate = windows.CBR_9600 // Default to 9600
 	} else {
@@ -202,9 +430,54 @@ func (port *windowsPort) setModeParams(mode *Mode, params *windows.DCB) {
 	}
 	params.StopBits = stopBitsMap[mode.StopBits]
 	params.Parity = parityMap[mode.Parity]
+
+	// Remove previous flow control setting, restoring the DTR and RTS
+	// lines to asserted if they were previously used for handshaking
+	params.Flags &^= dcbOutXCTSFlow
+	params.Flags &^= dcbOutXDSRFlow
+	params.Flags &^= dcbInX
+	params.Flags &^= dcbOutX
+	if params.Flags&^dcbDTRControlDisableMask == dcbDTRControlHandshake {
+		params.Flags &= dcbDTRControlDisableMask
+		params.Flags |= dcbDTRControlEnable
+	}
+	if params.Flags&^dcbRTSControlDisableMask == dcbRTSControlHandshake {
+		params.Flags &= dcbRTSControlDisableMask
+		params.Flags |= dcbRTSControlEnable
+	}
+
+	switch mode.FlowControl {
+	case NoFlowControl:
+	case RTSCTSFlowControl:
+		params.Flags |= dcbOutXCTSFlow
+		params.Flags &= dcbRTSControlDisableMask
+		params.Flags |= dcbRTSControlHandshake
+	case XONXOFFFlowControl:
+		params.Flags |= dcbInX
+		params.Flags |= dcbOutX
+		params.XonChar = defaultXonChar
+		if mode.XonChar != 0 {
+			params.XonChar = mode.XonChar
+		}
+		params.XoffChar = defaultXoffChar
+		if mode.XoffChar != 0 {
+			params.XoffChar = mode.XoffChar
+		}
+	case DTRDSRFlowControl:
+		params.Flags |= dcbOutXDSRFlow
+		params.Flags &= dcbDTRControlDisableMask
+		params.Flags |= dcbDTRControlHandshake
+	default:
+		return &PortError{code: InvalidFlowControl}
+	}
+	return nil
 }
 
 func (port *windowsPort) SetDTR(dtr bool) error {
+	return port.opError("set DTR", port.setDTR(dtr))
+}
+
+func (port *windowsPort) setDTR(dtr bool) error {
 	// Like for RTS there are problems with the windows.EscapeCommFunction
 	// observed behaviour was that DTR is set from false -> true
 	// when setting RTS from true -> false
@@ -230,20 +503,24 @@ func (port *windowsPort) SetDTR(dtr bool) error {
 
 	params := &windows.DCB{}
 	if err := windows.GetCommState(port.handle, params); err != nil {
-		return &PortError{causedBy: err}
+		return err
 	}
 	params.Flags &= dcbDTRControlDisableMask
 	if dtr {
 		params.Flags |= windows.DTR_CONTROL_ENABLE
 	}
 	if err := windows.SetCommState(port.handle, params); err != nil {
-		return &PortError{causedBy: err}
+		return err
 	}
 
 	return nil
 }
 
 func (port *windowsPort) SetRTS(rts bool) error {
+	return port.opError("set RTS", port.setRTS(rts))
+}
+
+func (port *windowsPort) setRTS(rts bool) error {
 	// It seems that there is a bug in the Windows VCP driver:
 	// it doesn't send USB control message when the RTS bit is
 	// changed, so the following code not always works with
@@ -268,18 +545,43 @@ func (port *windowsPort) SetRTS(rts bool) error {
 
 	params := &windows.DCB{}
 	if err := windows.GetCommState(port.handle, params); err != nil {
-		return &PortError{causedBy: err}
+		return err
 	}
 	params.Flags &= dcbRTSControlDisableMask
 	if rts {
 		params.Flags |= windows.RTS_CONTROL_ENABLE
 	}
 	if err := windows.SetCommState(port.handle, params); err != nil {
-		return &PortError{causedBy: err}
+		return err
 	}
 	return nil
 }
 
+func (port *windowsPort) InputWaiting() (int, error) {
+	stat, err := port.getCommStat()
+	if err != nil {
+		return 0, port.opError("input waiting", err)
+	}
+	return int(stat.CBInQue), nil
+}
+
+func (port *windowsPort) OutputWaiting() (int, error) {
+	stat, err := port.getCommStat()
+	if err != nil {
+		return 0, port.opError("output waiting", err)
+	}
+	return int(stat.CBOutQue), nil
+}
+
+func (port *windowsPort) getCommStat() (*windows.ComStat, error) {
+	var errFlags uint32
+	stat := &windows.ComStat{}
+	if err := windows.ClearCommError(port.handle, &errFlags, stat); err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
 func (port *windowsPort) GetModemStatusBits() (*ModemStatusBits, error) {
 	// GetCommModemStatus constants. See https://learn.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-getcommmodemstatus.
 	const (
@@ -290,7 +592,7 @@ func (port *windowsPort) GetModemStatusBits() (*ModemStatusBits, error) {
 	)
 	var bits uint32
 	if err := windows.GetCommModemStatus(port.handle, &bits); err != nil {
-		return nil, &PortError{}
+		return nil, port.opError("get modem status bits", err)
 	}
 	return &ModemStatusBits{
 		CTS: (bits & MS_CTS_ON) != 0,
@@ -332,6 +634,7 @@ func (port *windowsPort) SetReadTimeout(timeout time.Duration) error {
 
 	port.mu.Lock()
 	defer port.mu.Unlock()
+	commTimeouts.WriteTotalTimeoutConstant = port.writeTimeout
 	if err := windows.SetCommTimeouts(port.handle, commTimeouts); err != nil {
 		return &PortError{code: InvalidTimeoutValue, causedBy: err}
 	}
@@ -340,15 +643,40 @@ func (port *windowsPort) SetReadTimeout(timeout time.Duration) error {
 	return nil
 }
 
+func (port *windowsPort) SetWriteTimeout(timeout time.Duration) error {
+	var ms uint32 // 0 means no timeout
+	if timeout != NoTimeout {
+		if timeout < 0 || timeout.Milliseconds() > 0xFFFFFFFE {
+			return &PortError{code: InvalidTimeoutValue}
+		}
+		// Round up to 1ms, a 0 timeout would be interpreted as no timeout
+		ms = uint32(max(timeout.Milliseconds(), 1))
+	}
+
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	commTimeouts := &windows.CommTimeouts{}
+	if err := windows.GetCommTimeouts(port.handle, commTimeouts); err != nil {
+		return &PortError{code: InvalidTimeoutValue, causedBy: err}
+	}
+	commTimeouts.WriteTotalTimeoutConstant = ms
+	commTimeouts.WriteTotalTimeoutMultiplier = 0
+	if err := windows.SetCommTimeouts(port.handle, commTimeouts); err != nil {
+		return &PortError{code: InvalidTimeoutValue, causedBy: err}
+	}
+	port.writeTimeout = ms
+	return nil
+}
+
 func (port *windowsPort) Break(d time.Duration) error {
 	if err := windows.SetCommBreak(port.handle); err != nil {
-		return &PortError{causedBy: err}
+		return port.opError("break", err)
 	}
 
 	time.Sleep(d)
 
 	if err := windows.ClearCommBreak(port.handle); err != nil {
-		return &PortError{causedBy: err}
+		return port.opError("break", err)
 	}
 
 	return nil
@@ -359,13 +687,15 @@ func createOverlappedEvent() (*windows.Overlapped, error) {
 	return &windows.Overlapped{HEvent: h}, err
 }
 
-func nativeOpen(portName string, mode *Mode) (*windowsPort, error) {
+func nativeOpen(portName string, options *openOptions) (*windowsPort, error) {
+	mode := options.mode
+	name := portName
 	if !strings.HasPrefix(portName, `\\.\`) {
 		portName = `\\.\` + portName
 	}
 	path, err := windows.UTF16PtrFromString(portName)
 	if err != nil {
-		return nil, err
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 	handle, err := windows.CreateFile(
 		path,
@@ -378,58 +708,62 @@ func nativeOpen(portName string, mode *Mode) (*windowsPort, error) {
 	if err != nil {
 		switch err {
 		case windows.ERROR_ACCESS_DENIED:
-			return nil, &PortError{code: PortBusy}
+			return nil, &PortError{code: PortBusy, causedBy: err}
 		case windows.ERROR_FILE_NOT_FOUND:
-			return nil, &PortError{code: PortNotFound}
+			return nil, &PortError{code: PortNotFound, causedBy: err}
 		}
-		return nil, err
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 	// Create the serial port
 	port := &windowsPort{
 		handle: handle,
+		name:   name,
 	}
 
 	// Set port parameters
 	params := &windows.DCB{}
-	if windows.GetCommState(port.handle, params) != nil {
+	if err := windows.GetCommState(port.handle, params); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
-	port.setModeParams(mode, params)
-	params.Flags &= dcbDTRControlDisableMask
-	params.Flags &= dcbRTSControlDisableMask
-	if mode.InitialStatusBits == nil {
-		params.Flags |= windows.DTR_CONTROL_ENABLE
-		params.Flags |= windows.RTS_CONTROL_ENABLE
-	} else {
-		if mode.InitialStatusBits.DTR {
+	if !options.keepModemLines {
+		params.Flags &= dcbDTRControlDisableMask
+		params.Flags &= dcbRTSControlDisableMask
+		if mode.InitialStatusBits == nil {
 			params.Flags |= windows.DTR_CONTROL_ENABLE
-		}
-		if mode.InitialStatusBits.RTS {
 			params.Flags |= windows.RTS_CONTROL_ENABLE
+		} else {
+			if mode.InitialStatusBits.DTR {
+				params.Flags |= windows.DTR_CONTROL_ENABLE
+			}
+			if mode.InitialStatusBits.RTS {
+				params.Flags |= windows.RTS_CONTROL_ENABLE
+			}
 		}
 	}
-	params.Flags &^= dcbOutXCTSFlow
-	params.Flags &^= dcbOutXDSRFlow
-	params.Flags &^= dcbDSRSensitivity
-	params.Flags |= dcbTXContinueOnXOFF
-	params.Flags &^= dcbInX
-	params.Flags &^= dcbOutX
-	params.Flags &^= dcbErrorChar
-	params.Flags &^= dcbNull
-	params.Flags &^= dcbAbortOnError
-	params.XonLim = 2048
-	params.XoffLim = 512
-	params.XonChar = 17  // DC1
-	params.XoffChar = 19 // C3
-	if windows.SetCommState(port.handle, params) != nil {
+	if !options.keepTermSettings {
+		params.Flags &^= dcbDSRSensitivity
+		params.Flags |= dcbTXContinueOnXOFF
+		params.Flags &^= dcbErrorChar
+		params.Flags &^= dcbNull
+		params.Flags &^= dcbAbortOnError
+		params.XonLim = 2048
+		params.XoffLim = 512
+		params.XonChar = 17  // DC1
+		params.XoffChar = 19 // C3
+		if err := port.setModeParams(mode, params); err != nil {
+			port.Close()
+			return nil, err
+		}
+	}
+	if err := windows.SetCommState(port.handle, params); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 
-	if port.SetReadTimeout(NoTimeout) != nil {
+	if err := port.SetReadTimeout(options.readTimeout); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, err
 	}
 	return port, nil
 }
@@ -0,0 +1,80 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestSystemErrors(t *testing.T) {
+	// Every call on an invalid handle fails with a system error
+	port := &windowsPort{handle: windows.InvalidHandle, name: "COM99"}
+	tests := []struct {
+		op  string
+		err error
+	}{
+		{"set DTR", port.SetDTR(true)},
+		{"set RTS", port.SetRTS(true)},
+		{"break", port.Break(0)},
+		{"drain", port.Drain()},
+		{"reset input buffer", port.ResetInputBuffer()},
+		{"reset output buffer", port.ResetOutputBuffer()},
+	}
+	_, err := port.GetModemStatusBits()
+	tests = append(tests, struct {
+		op  string
+		err error
+	}{"get modem status bits", err})
+	for _, test := range tests {
+		portErr, ok := test.err.(*PortError)
+		if !ok || portErr.Code() != InvalidSerialPort || errors.Is(test.err, ErrPortBusy) {
+			t.Errorf("%s: expected InvalidSerialPort error, got %v", test.op, test.err)
+			continue
+		}
+		if portErr.Op != test.op || portErr.Port != "COM99" || portErr.Unwrap() == nil {
+			t.Errorf("%s: unexpected error %+v", test.op, portErr)
+		}
+	}
+}
+
+func TestDeviceError(t *testing.T) {
+	open := &windowsPort{handle: windows.InvalidHandle}
+	closed := &windowsPort{}
+	tests := []struct {
+		port     *windowsPort
+		err      error
+		expected error
+	}{
+		{open, windows.ERROR_DEVICE_NOT_CONNECTED, ErrDeviceRemoved},
+		{open, windows.ERROR_BAD_COMMAND, ErrDeviceRemoved},
+		{open, windows.ERROR_ACCESS_DENIED, ErrDeviceRemoved},
+		{open, windows.ERROR_OPERATION_ABORTED, ErrDeviceRemoved},
+		{closed, windows.ERROR_OPERATION_ABORTED, ErrPortClosed},
+		{open, windows.ERROR_INVALID_PARAMETER, windows.ERROR_INVALID_PARAMETER},
+	}
+	for _, test := range tests {
+		err := test.port.deviceError(test.err)
+		if !errors.Is(err, test.expected) || !errors.Is(err, test.err) {
+			t.Errorf("%v: expected %v, got %v", test.err, test.expected, err)
+		}
+	}
+}
+
+func TestIOCancellerStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newIOCanceller(windows.InvalidHandle, &windows.Overlapped{}, ctx)
+	cancel()
+	// stop waits for the pending cancellation
+	c.stop()
+	if !c.isCanceled() {
+		t.Fatal("stop returned before the cancellation")
+	}
+}
@@ -0,0 +1,37 @@
+//
+// Copyright 2014-2024 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+//go:build linux || darwin || freebsd || openbsd
+
+package unixutils
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Poll performs a poll system call on the file descriptors in fds, testing
+// each one for the events in its Events field. The function will block until
+// an event happens or the timeout expires (a negative timeout means no timeout,
+// a positive timeout is rounded up to the next millisecond). The events that
+// happened are reported in the Revents field of each file descriptor and the
+// number of file descriptors with a pending event is returned.
+//
+// Unlike Select, Poll works with file descriptors of any value.
+// Note: on darwin (MacOSX) poll doesn't support devices, only pipes and sockets.
+func Poll(fds []unix.PollFd, timeout time.Duration) (int, error) {
+	ms := -1
+	if timeout >= 0 {
+		d := timeout / time.Millisecond
+		if timeout%time.Millisecond != 0 {
+			d++
+		}
+		ms = int(min(d, math.MaxInt32))
+	}
+	return unix.Poll(fds, ms)
+}